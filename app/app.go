@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/fiberzap/v2"
@@ -14,6 +16,8 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/google/uuid"
+	"github.com/stoewer/go-strcase"
+	"github.com/valyala/fasthttp"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -35,6 +39,11 @@ type EndPoint struct {
 	List          bool
 	Name          string
 	Description   string
+	CacheTTL      time.Duration
+	CacheDepends  []string
+	RateLimit     *RateLimit
+	Transactional bool
+	RPCMethod     string
 	path          string
 	docpath       string
 }
@@ -48,32 +57,71 @@ type ModelInterface interface {
 	Generate()
 	GetModelType() interface{}
 	GetName() string
-	SetDb(*mongo.Database)
+	GetIdStrategy() string
+	SetStore(Store)
+	SetCache(Cache)
+	GetTenantField() string
+	SetTenantCollections(map[string]string)
+	OnChange(func(*ChangeEvent))
+	SetEventBus(EventBus)
+	SetLogger(*zap.Logger)
+	SetBaseContext(context.Context)
+	UpsertFixture(*fiber.Ctx, M) (interface{}, error)
 }
 type DefaultQuery struct {
-	Offset int64 `json:"offset,omitempty"`
-	Limit  int64 `json:"limit,omitempty"`
+	Offset int64  `json:"offset,omitempty" query:"offset"`
+	Limit  int64  `json:"limit,omitempty" query:"limit"`
+	Sort   string `json:"sort,omitempty" query:"sort"`
 }
 
 type App struct {
-	models         []ModelInterface
-	dbCon          *mongo.Database
-	mongoClient    *mongo.Client
-	authMiddleware func(*fiber.Ctx) (M, error)
-	GetEndPoints   []*EndPoint
-	PostEndPoints  []*EndPoint
-	conurl         string
-	logPath        string
-	dbName         string
-	fiberApp       *fiber.App
-	currentCtx     *fiber.Ctx
-	errorLogger    *zap.Logger
-	Name           string
-	Description    string
-	BaseURL        string
-	SaveLog        bool
-	LogLife        time.Duration
-	Debug          bool
+	models             []ModelInterface
+	dbCon              *mongo.Database
+	mongoClient        *mongo.Client
+	store              Store
+	authMiddleware     func(*fiber.Ctx) (M, error)
+	webhookAuthorizer  func(*fiber.Ctx) error
+	cache              Cache
+	tenantFields       map[string]string
+	eventBus           EventBus
+	migrations         []*Migration
+	healthChecks       []*healthCheck
+	rateLimitStore     RateLimitStore
+	GetEndPoints       []*EndPoint
+	PostEndPoints      []*EndPoint
+	conurl             string
+	logPath            string
+	dbName             string
+	fiberApp           *fiber.App
+	rpcMethods         map[string]*rpcMethod
+	rpcDispatch        fasthttp.RequestHandler
+	shutdownHooks      []*shutdownHook
+	pending            sync.WaitGroup
+	stopWorkers        context.CancelFunc
+	baseCtx            context.Context
+	stopStreams        context.CancelFunc
+	shuttingDown       atomic.Bool
+	indexesDone        atomic.Bool
+	indexesErr         error
+	logger             *zap.Logger
+	errorLogger        *zap.Logger
+	Name               string
+	Description        string
+	BaseURL            string
+	SaveLog            bool
+	LogLife            time.Duration
+	Idempotency        bool
+	IdempotencyLife    time.Duration
+	Webhooks           bool
+	WebhookMaxAttempts int
+	MigrationDryRun    bool
+	Debug              bool
+	Envelope           Envelope
+	JSONAPI            bool
+	GraphQL            bool
+	RPC                bool
+	DrainTimeout       time.Duration
+	DrainDelay         time.Duration
 }
 
 func (app *App) CreateConnection() {
@@ -85,6 +133,10 @@ func (app *App) CreateConnection() {
 	}
 	app.mongoClient = client
 	app.dbCon = app.mongoClient.Database(app.dbName)
+	app.store = NewMongoStore(app.dbCon)
+}
+func (app *App) Store() Store {
+	return app.store
 }
 func (app *App) SetAuthMiddleware(fnc func(*fiber.Ctx) (M, error)) {
 	app.authMiddleware = fnc
@@ -92,7 +144,6 @@ func (app *App) SetAuthMiddleware(fnc func(*fiber.Ctx) (M, error)) {
 func (app *App) getPathName(c *fiber.Ctx) string {
 
 	for _, rn := range c.App().GetRoutes() {
-
 		/*isMatch := RoutePatternMatch(c.Path(), rn.Path, fiber.Config{
 			CaseSensitive: false,
 			StrictRouting: false,
@@ -109,45 +160,59 @@ func (app *App) getPathName(c *fiber.Ctx) string {
 	}
 	return ""
 }
-func (app *App) authControl(c *fiber.Ctx) error {
+func routeMatch(c *fiber.Ctx, pattern string) bool {
+	return fiber.RoutePatternMatch(strings.TrimSuffix(c.Path(), "/"), strings.TrimSuffix(pattern, "/"))
+}
+
+// matchEndpoint resolves the endpoint serving the request. Fiber shares route
+// names across methods on the same path, so the method's endpoint list is
+// matched by route pattern as well as by name.
+func (app *App) matchEndpoint(c *fiber.Ctx) (*EndPoint, ModelInterface) {
 	elmPath := strings.ReplaceAll(c.OriginalURL(), "/api/", "")
 	knowName := app.getPathName(c)
-	if app.authMiddleware != nil {
-		route := c.Route()
-
-		var founded *EndPoint
-
-		for i := range app.models {
-			var enpoints []*EndPoint
-			switch route.Method {
-			case "GET":
-				enpoints = app.models[i].GetEndPoints()
-			case "POST":
-				enpoints = app.models[i].PostEndPoints()
-			}
-			for _, endpoint := range enpoints {
-				if strings.EqualFold(endpoint.path, elmPath) || endpoint.Name == knowName {
-					founded = endpoint
-					c.Locals("model", app.models[i])
-					break
-				}
-			}
-
-		}
+	c.Append("X-REQUEST-MTH", knowName)
+	matches := func(endpoint *EndPoint, pattern string) bool {
+		return strings.EqualFold(endpoint.path, elmPath) || endpoint.Name == knowName || routeMatch(c, pattern)
+	}
+	method := c.Route().Method
+	for i := range app.models {
 		var enpoints []*EndPoint
-		c.Append("X-REQUEST-MTH", knowName)
-		switch route.Method {
+		switch method {
 		case "GET":
-			enpoints = app.GetEndPoints
+			enpoints = app.models[i].GetEndPoints()
 		case "POST":
-			enpoints = app.PostEndPoints
+			enpoints = app.models[i].PostEndPoints()
+		case "PUT":
+			enpoints = app.models[i].PutEndPoints()
+		case "DELETE":
+			enpoints = app.models[i].DeleteEndPoints()
 		}
-		for i := range enpoints {
-			if strings.EqualFold(enpoints[i].path, elmPath) || enpoints[i].Name == knowName {
-				founded = enpoints[i]
-				break
+		for _, endpoint := range enpoints {
+			if matches(endpoint, "/api/"+endpoint.path) {
+				return endpoint, app.models[i]
 			}
 		}
+	}
+	var enpoints []*EndPoint
+	switch method {
+	case "GET":
+		enpoints = app.GetEndPoints
+	case "POST":
+		enpoints = app.PostEndPoints
+	}
+	for i := range enpoints {
+		if matches(enpoints[i], enpoints[i].path) {
+			return enpoints[i], nil
+		}
+	}
+	return nil, nil
+}
+func (app *App) authControl(c *fiber.Ctx) error {
+	if app.authMiddleware != nil {
+		founded, model := app.matchEndpoint(c)
+		if model != nil {
+			c.Locals("model", model)
+		}
 		c.Locals("endpoint", founded)
 		if founded != nil {
 			c.Append("X-REQUEST-FND", founded.Name)
@@ -157,11 +222,7 @@ func (app *App) authControl(c *fiber.Ctx) error {
 
 			extraQuery, err := app.authMiddleware(c)
 			if err != nil {
-				return c.Status(401).JSON(Response{
-					Message:    "Unauthorized",
-					StatusCode: 401,
-					Error:      err.Error(),
-				})
+				return RError(c, 401, "Unauthorized", err.Error())
 			}
 			c.Locals("authQuery", extraQuery)
 			return c.Next()
@@ -194,23 +255,63 @@ func (app *App) RegisterPostEndpoint(path string, isPublic bool, request interfa
 	app.PostEndPoints = append(app.PostEndPoints, end)
 	return end
 }
+func (app *App) endpointHandler(end *EndPoint, method string) func(*fiber.Ctx) error {
+	fnc := end.function
+	if end.Transactional {
+		fnc = app.transactionHandler(fnc)
+	}
+	switch method {
+	case fiber.MethodGet:
+		fnc = app.cacheHandler(end, fnc)
+	case fiber.MethodPost:
+		if end.IsAggregade {
+			fnc = app.cacheHandler(end, fnc)
+		}
+		fnc = app.idempotencyHandler(fnc)
+	}
+	return app.rateLimitHandler(end, fnc)
+}
 func (app *App) RegisterModel(item ModelInterface) {
-	item.SetDb(app.dbCon)
+	item.SetStore(app.store)
+	item.SetCache(app.cache)
+	item.SetTenantCollections(app.tenantFields)
+	item.OnChange(app.handleChange)
+	item.SetEventBus(app.eventBus)
+	item.SetLogger(app.errorLogger)
+	item.SetBaseContext(app.baseCtx)
 	item.Generate()
+	if field := item.GetTenantField(); field != "" {
+		app.tenantFields[strcase.SnakeCase(item.GetName())] = field
+	}
 	app.models = append(app.models, item)
 }
 
 func New(con string, db string, logPath string) *App {
 	app := &App{
-		conurl:  con,
-		dbName:  db,
-		logPath: logPath,
+		conurl:       con,
+		dbName:       db,
+		logPath:      logPath,
+		tenantFields: map[string]string{},
 	}
+	app.logger = app.GetZap()
 	app.errorLogger = app.GetErrorZap()
+	app.baseCtx, app.stopStreams = context.WithCancel(context.Background())
 	app.CreateConnection()
 	return app
 }
 
+func NewWithStore(store Store, logPath string) *App {
+	app := &App{
+		store:        store,
+		logPath:      logPath,
+		tenantFields: map[string]string{},
+	}
+	app.logger = app.GetZap()
+	app.errorLogger = app.GetErrorZap()
+	app.baseCtx, app.stopStreams = context.WithCancel(context.Background())
+	return app
+}
+
 func (app *App) GetZap() *zap.Logger {
 	filepath := filepath.Join(app.logPath, "app.log")
 	file := zapcore.AddSync(&lumberjack.Logger{
@@ -258,20 +359,10 @@ func (app *App) GetErrorZap() *zap.Logger {
 
 	return zap.New(core)
 }
-func (app *App) FindCollection(collection string, query M) (*mongo.Cursor, error) {
-	return app.dbCon.Collection(collection).Find(app.currentCtx.Context(), query)
-}
-
-func (app *App) FindOneCollection(collection string, query M) *mongo.SingleResult {
-	return app.dbCon.Collection(collection).FindOne(app.currentCtx.Context(), query)
-}
-func (app *App) AggrageteCollection(collection string, query []M) (*mongo.Cursor, error) {
-	return app.dbCon.Collection(collection).Aggregate(app.currentCtx.Context(), query)
-}
-func (app *App) LogDbInit() {
+func (app *App) LogDbInit() error {
 	collections, err := app.dbCon.ListCollectionNames(context.Background(), M{})
 	if err != nil {
-		panic(err)
+		return err
 	}
 	created := false
 	for _, item := range collections {
@@ -284,7 +375,7 @@ func (app *App) LogDbInit() {
 		err := app.dbCon.CreateCollection(context.Background(), "fimapi_api_log")
 
 		if err != nil {
-			panic(err)
+			return err
 		}
 		col := app.dbCon.Collection("fimapi_api_log")
 		var indexes []mongo.IndexModel
@@ -296,16 +387,103 @@ func (app *App) LogDbInit() {
 			},
 		}
 		indexes = append(indexes, indexAfterClear)
-		resp, err := col.Indexes().CreateOne(context.Background(), indexAfterClear)
-		fmt.Println("Index Create", resp, err)
+		_, err = col.Indexes().CreateOne(context.Background(), indexAfterClear)
+		return err
 	}
+	return nil
 }
-func (app *App) Run(host string) {
+func (app *App) createIndexes() error {
 	if app.SaveLog {
 		if app.LogLife.Milliseconds() == 0 {
 			app.LogLife = time.Hour * 24 * 10
-			app.LogDbInit()
 		}
+		err := app.LogDbInit()
+		if err != nil {
+			return err
+		}
+	}
+	if app.Idempotency {
+		err := app.IdempotencyDbInit()
+		if err != nil {
+			return err
+		}
+	}
+	if app.Webhooks {
+		return app.WebhookDbInit()
+	}
+	return nil
+}
+func (app *App) requireMongo() error {
+	if app.dbCon == nil && (app.SaveLog || len(app.migrations) > 0) {
+		return errors.New("api log and migrations require a mongo connection")
+	}
+	return nil
+}
+func (app *App) Run(host string) error {
+	if app.MigrationDryRun {
+		err := app.requireMongo()
+		if err != nil {
+			return err
+		}
+		pending, err := app.PendingMigrations(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, item := range pending {
+			app.logger.Info("pending migration", zap.Int64("version", item.Version), zap.String("name", item.Name))
+		}
+		return nil
+	}
+	fapp, err := app.build()
+	if err != nil {
+		return err
+	}
+	return fapp.Listen(host)
+}
+func (app *App) Build() *fiber.App {
+	fapp, err := app.build()
+	if err != nil {
+		panic(err)
+	}
+	return fapp
+}
+func (app *App) build() (*fiber.App, error) {
+	if app.fiberApp != nil {
+		return app.fiberApp, nil
+	}
+	err := app.requireMongo()
+	if err != nil {
+		return nil, err
+	}
+	err = app.Migrate(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	app.indexesErr = app.createIndexes()
+	app.indexesDone.Store(true)
+	if app.indexesErr != nil {
+		return nil, app.indexesErr
+	}
+	if app.Webhooks {
+		err = app.registerWebhookEndpoints()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if app.GraphQL {
+		err = app.registerGraphQL()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if app.RPC {
+		err = app.registerRPC()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if app.rateLimitStore == nil {
+		app.rateLimitStore = NewMemoryRateLimitStore()
 	}
 	fConfig := fiber.Config{
 		ReadTimeout:  time.Second * 10,
@@ -326,14 +504,34 @@ func (app *App) Run(host string) {
 				errid = eid
 
 			}
-			return ctx.Status(code).JSON(Response{
-				StatusCode: 500,
-				Error:      M{"error_id": errid},
-				Message:    "internal server error",
-			})
+			message := "internal server error"
+			if e != nil && code != fiber.StatusInternalServerError {
+				message = e.Message
+			}
+			env, ok := ctx.Locals(envelopeKey).(Envelope)
+			if !ok {
+				env = app.envelope()
+			}
+			return env.Failure(ctx, code, message, M{"error_id": errid})
 		}
 	}
 	fapp := fiber.New(fConfig)
+	fapp.Use(func(c *fiber.Ctx) error {
+		env := app.envelope()
+		if c.Locals(rpcCallKey) != nil {
+			env = DefaultEnvelope{}
+		} else if acceptsJSONAPI(c) {
+			env = app.jsonAPIEnvelope()
+		}
+		c.Locals(envelopeKey, env)
+		if strings.HasPrefix(c.Get(fiber.HeaderContentType), jsonAPIMediaType) && len(c.Body()) > 0 {
+			err := unwrapJSONAPIBody(c)
+			if err != nil {
+				return env.Failure(c, 400, "invalid json:api document", err.Error())
+			}
+		}
+		return c.Next()
+	})
 	if app.SaveLog {
 		fapp.Use(func(c *fiber.Ctx) error {
 			t := time.Now()
@@ -364,16 +562,14 @@ func (app *App) Run(host string) {
 				RequestHeaders:  c.GetReqHeaders(),
 				ResponseHeaders: c.GetRespHeaders(),
 			}
-			go func(bapp *App, item ApiLog) {
-
-				bapp.dbCon.Collection("fimapi_api_log").InsertOne(context.Background(), item)
-
-			}(app, logItem)
+			app.background(func() {
+				app.dbCon.Collection("fimapi_api_log").InsertOne(context.Background(), logItem)
+			})
 			return respData
 		})
 	}
 	fapp.Use(fiberzap.New(fiberzap.Config{
-		Logger: app.GetZap(),
+		Logger: app.logger,
 		FieldsFunc: func(c *fiber.Ctx) []zap.Field {
 			var fields []zap.Field
 			reqId := c.UserContext().Value("request_id").(string)
@@ -382,7 +578,6 @@ func (app *App) Run(host string) {
 		},
 	}))
 	fapp.Use(func(c *fiber.Ctx) error {
-		app.currentCtx = c
 		reqUUid := uuid.NewString()
 		ctx := context.WithValue(c.UserContext(), "request_id", reqUUid)
 		c.Append("X-REQUEST-ID", reqUUid)
@@ -390,6 +585,8 @@ func (app *App) Run(host string) {
 		return c.Next()
 	})
 	fapp.Get("/metrics", monitor.New())
+	fapp.Get("/healthz", app.livenessHandler)
+	fapp.Get("/readyz", app.readinessHandler)
 	if !app.Debug {
 
 		fapp.Use(recover.New(
@@ -406,11 +603,11 @@ func (app *App) Run(host string) {
 	NewDoc(app)
 	fapp.Use(app.authControl)
 	for _, end := range app.GetEndPoints {
-		fapp.Get(end.path, end.function).Name(end.Name)
+		fapp.Get(end.path, app.endpointHandler(end, fiber.MethodGet)).Name(end.Name)
 	}
 
 	for _, end := range app.PostEndPoints {
-		fapp.Post(end.path, end.function).Name(end.Name)
+		fapp.Post(end.path, app.endpointHandler(end, fiber.MethodPost)).Name(end.Name)
 	}
 	for i := range app.models {
 		endppints := app.models[i].GetEndPoints()
@@ -418,18 +615,28 @@ func (app *App) Run(host string) {
 		endppintput := app.models[i].PutEndPoints()
 		endppintdelete := app.models[i].DeleteEndPoints()
 		for iget := range endppintdelete {
-			fapp.Delete(fmt.Sprintf("api/%s", endppints[iget].path), endppints[iget].function).Name(endppints[iget].Name)
+			fapp.Delete(fmt.Sprintf("api/%s", endppintdelete[iget].path), app.endpointHandler(endppintdelete[iget], fiber.MethodDelete)).Name(endppintdelete[iget].Name)
 		}
 		for iget := range endppintput {
-			fapp.Put(fmt.Sprintf("api/%s", endppints[iget].path), endppints[iget].function).Name(endppints[iget].Name)
+			fapp.Put(fmt.Sprintf("api/%s", endppintput[iget].path), app.endpointHandler(endppintput[iget], fiber.MethodPut)).Name(endppintput[iget].Name)
 		}
 		for iget := range endppints {
-			fapp.Get(fmt.Sprintf("api/%s", endppints[iget].path), endppints[iget].function).Name(endppints[iget].Name)
+			fapp.Get(fmt.Sprintf("api/%s", endppints[iget].path), app.endpointHandler(endppints[iget], fiber.MethodGet)).Name(endppints[iget].Name)
 		}
 		for iget := range endppintspost {
-			fapp.Post(fmt.Sprintf("api/%s", endppintspost[iget].path), endppintspost[iget].function).Name(endppints[iget].Name)
+			fapp.Post(fmt.Sprintf("api/%s", endppintspost[iget].path), app.endpointHandler(endppintspost[iget], fiber.MethodPost)).Name(endppintspost[iget].Name)
 		}
 	}
 	app.fiberApp = fapp
-	fapp.Listen(host)
+	if app.RPC {
+		app.rpcDispatch = fapp.Handler()
+	}
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	app.stopWorkers = stopWorkers
+	if app.Webhooks {
+		app.background(func() {
+			app.webhookWorker(workerCtx)
+		})
+	}
+	return fapp, nil
 }
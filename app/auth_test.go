@@ -0,0 +1,36 @@
+package app_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Card struct {
+	Title string
+}
+
+func TestAuthRunsForEveryModelMethod(t *testing.T) {
+	h := apptest.New(t)
+	cards := app.NewModel[Card]("card")
+	h.Register(cards)
+	ids := h.Seed(cards, app.M{"title": "first"})
+	path := fmt.Sprintf("/api/card/%s", ids[0].(primitive.ObjectID).Hex())
+
+	h.Get(path).AssertStatus(401)
+	h.Get("/api/card/").AssertStatus(401)
+	h.Post("/api/card/", app.M{"title": "second"}).AssertStatus(401)
+	h.Put(path, app.M{"title": "changed"}).AssertStatus(401)
+	h.Delete(path).AssertStatus(401)
+	docs := h.Documents(cards)
+	if len(docs) != 1 || docs[0]["title"] != "first" {
+		t.Fatalf("expected unauthenticated requests to leave data untouched, got %v", docs)
+	}
+
+	client := h.As(app.M{})
+	client.Put(path, app.M{"title": "changed"}).AssertStatus(200)
+	client.Delete(path).AssertStatus(200)
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CacheItem struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+type Cache interface {
+	Get(key string) (*CacheItem, bool)
+	Set(key string, item *CacheItem, ttl time.Duration, tags []string)
+	Invalidate(tag string)
+}
+
+type memoryCacheEntry struct {
+	key     string
+	item    *CacheItem
+	expires time.Time
+	tags    []string
+}
+
+type MemoryCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+}
+
+func NewMemoryCache(size int) *MemoryCache {
+	if size <= 0 {
+		size = 1000
+	}
+	return &MemoryCache{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+		tags:  map[string]map[string]struct{}{},
+	}
+}
+
+func (mc *MemoryCache) Get(key string) (*CacheItem, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	elm, ok := mc.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elm.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expires) {
+		mc.remove(elm)
+		return nil, false
+	}
+	mc.order.MoveToFront(elm)
+	return entry.item, true
+}
+
+func (mc *MemoryCache) Set(key string, item *CacheItem, ttl time.Duration, tags []string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if elm, ok := mc.items[key]; ok {
+		mc.remove(elm)
+	}
+	entry := &memoryCacheEntry{
+		key:     key,
+		item:    item,
+		expires: time.Now().Add(ttl),
+		tags:    tags,
+	}
+	mc.items[key] = mc.order.PushFront(entry)
+	for _, tag := range tags {
+		if _, ok := mc.tags[tag]; !ok {
+			mc.tags[tag] = map[string]struct{}{}
+		}
+		mc.tags[tag][key] = struct{}{}
+	}
+	for mc.order.Len() > mc.size {
+		mc.remove(mc.order.Back())
+	}
+}
+
+func (mc *MemoryCache) Invalidate(tag string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for key := range mc.tags[tag] {
+		if elm, ok := mc.items[key]; ok {
+			mc.remove(elm)
+		}
+	}
+	delete(mc.tags, tag)
+}
+
+func (mc *MemoryCache) remove(elm *list.Element) {
+	entry := elm.Value.(*memoryCacheEntry)
+	mc.order.Remove(elm)
+	delete(mc.items, entry.key)
+	for _, tag := range entry.tags {
+		if keys, ok := mc.tags[tag]; ok {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(mc.tags, tag)
+			}
+		}
+	}
+}
+
+// generationCache counts invalidations per tag so a response computed while
+// a write invalidated its tags is not stored afterwards.
+type generationCache struct {
+	Cache
+	mu          sync.Mutex
+	generations map[string]uint64
+}
+
+func (gc *generationCache) Invalidate(tag string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.generations[tag]++
+	gc.Cache.Invalidate(tag)
+}
+
+func (gc *generationCache) generation(tags []string) uint64 {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.sum(tags)
+}
+
+func (gc *generationCache) sum(tags []string) uint64 {
+	var total uint64
+	for _, tag := range tags {
+		total += gc.generations[tag]
+	}
+	return total
+}
+
+func (gc *generationCache) setIfCurrent(key string, item *CacheItem, ttl time.Duration, tags []string, generation uint64) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.sum(tags) != generation {
+		return
+	}
+	gc.Cache.Set(key, item, ttl, tags)
+}
+
+func (app *App) SetCache(cache Cache) {
+	if cache != nil {
+		cache = &generationCache{Cache: cache, generations: map[string]uint64{}}
+	}
+	app.cache = cache
+	for i := range app.models {
+		app.models[i].SetCache(cache)
+	}
+}
+
+func (app *App) cacheKey(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method()))
+	hash.Write([]byte(c.OriginalURL()))
+	if c.Locals(rpcCallKey) != nil {
+		hash.Write([]byte(rpcCallKey))
+	} else if acceptsJSONAPI(c) {
+		hash.Write([]byte(jsonAPIMediaType))
+	}
+	if c.Method() != fiber.MethodGet {
+		hash.Write(c.Body())
+	}
+	if authQuery := c.Locals("authQuery"); authQuery != nil {
+		scope, _ := json.Marshal(authQuery)
+		hash.Write(scope)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (app *App) cacheHandler(end *EndPoint, fnc func(*fiber.Ctx) error) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if app.cache == nil || end.CacheTTL <= 0 {
+			return fnc(c)
+		}
+		key := app.cacheKey(c)
+		if item, ok := app.cache.Get(key); ok {
+			c.Set("X-Cache", "HIT")
+			c.Set(fiber.HeaderContentType, item.ContentType)
+			return c.Status(item.StatusCode).Send(item.Body)
+		}
+		c.Set("X-Cache", "MISS")
+		cache := app.cache.(*generationCache)
+		generation := cache.generation(end.CacheDepends)
+		err := fnc(c)
+		if err == nil && c.Response().StatusCode() == fiber.StatusOK {
+			body := append([]byte(nil), c.Response().Body()...)
+			cache.setIfCurrent(key, &CacheItem{
+				StatusCode:  fiber.StatusOK,
+				ContentType: string(c.Response().Header.ContentType()),
+				Body:        body,
+			}, end.CacheTTL, end.CacheDepends, generation)
+		}
+		return err
+	}
+}
@@ -0,0 +1,60 @@
+package app_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Bulletin struct {
+	Text string
+}
+
+func TestCacheInvalidatesOnWrite(t *testing.T) {
+	h := apptest.New(t)
+	h.App.SetCache(app.NewMemoryCache(16))
+	bulletins := app.NewModel[Bulletin]("bulletin")
+	bulletins.CacheTTL = time.Minute
+	h.Register(bulletins)
+	client := h.As(app.M{})
+
+	for _, cache := range []string{"MISS", "HIT"} {
+		if got := client.Get("/api/bulletin/").AssertStatus(200).Header.Get("X-Cache"); got != cache {
+			t.Fatalf("expected cache %s, got %q", cache, got)
+		}
+	}
+	client.Post("/api/bulletin/", app.M{"text": "new"}).AssertStatus(201)
+	var list struct {
+		Items []Bulletin `json:"items"`
+	}
+	resp := client.Get("/api/bulletin/").AssertStatus(200).Result(&list)
+	if got := resp.Header.Get("X-Cache"); got != "MISS" || len(list.Items) != 1 {
+		t.Fatalf("expected a fresh list after the write, got %s %+v", got, list.Items)
+	}
+}
+
+func TestCacheSkipsResponsesRacingAWrite(t *testing.T) {
+	h := apptest.New(t)
+	h.App.SetCache(app.NewMemoryCache(16))
+	bulletins := app.NewModel[Bulletin]("bulletin")
+	h.Register(bulletins)
+	writes := 0
+	end := h.App.RegisterGetEndpoint("/bulletin-report", true, nil, nil, func(c *fiber.Ctx) error {
+		if writes == 0 {
+			writes++
+			h.Seed(bulletins, app.M{"text": "late"})
+		}
+		return c.SendString("report")
+	})
+	end.CacheTTL = time.Minute
+	end.CacheDepends = []string{"Bulletin"}
+
+	for _, cache := range []string{"MISS", "MISS", "HIT"} {
+		if got := h.Get("/bulletin-report").AssertStatus(200).Header.Get("X-Cache"); got != cache {
+			t.Fatalf("expected cache %s, got %q", cache, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stoewer/go-strcase"
+)
+
+type clientEndpoint struct {
+	name       string
+	httpMethod string
+	route      string
+	endpoint   *EndPoint
+}
+
+func clientEnvelopeModes(app *App) (string, string, error) {
+	switch env := app.envelope().(type) {
+	case BareEnvelope:
+		return "bare", "bare", nil
+	case ProblemEnvelope:
+		if env.Bare {
+			return "bare", "problem", nil
+		}
+		return "envelope", "problem", nil
+	case *JSONAPIEnvelope:
+		return "", "", fmt.Errorf("client generator does not support json:api responses")
+	}
+	return "envelope", "envelope", nil
+}
+
+func modelAccessOf(model ModelInterface) modelAccess {
+	if item, ok := model.(interface{ access() modelAccess }); ok {
+		return item.access()
+	}
+	return modelAccess{Get: true, List: true, Insert: true, Update: true, Delete: true}
+}
+
+func modelClientEndpoints(model ModelInterface) []clientEndpoint {
+	path := strcase.SnakeCase(model.GetName())
+	used := map[string]bool{"List": true, "Get": true, "Create": true, "Update": true, "Delete": true, "Restore": true}
+	var endpoints []clientEndpoint
+	for _, group := range []struct {
+		method    string
+		endpoints []*EndPoint
+	}{{"GET", model.GetEndPoints()}, {"POST", model.PostEndPoints()}} {
+		for _, end := range group.endpoints {
+			if !end.IsAggregade || end.RPCMethod == "-" {
+				continue
+			}
+			name := strcase.UpperCamelCase(strings.ReplaceAll(strings.TrimPrefix(end.path, path+"/"), "/", "_"))
+			if used[name] {
+				name += strcase.UpperCamelCase(strings.ToLower(group.method))
+			}
+			used[name] = true
+			endpoints = append(endpoints, clientEndpoint{name: name, httpMethod: group.method, route: "/api/" + end.path, endpoint: end})
+		}
+	}
+	return endpoints
+}
+
+func (app *App) clientEndpoints(used map[string]bool) []clientEndpoint {
+	var endpoints []clientEndpoint
+	for _, group := range []struct {
+		method    string
+		endpoints []*EndPoint
+	}{{"GET", app.GetEndPoints}, {"POST", app.PostEndPoints}} {
+		for _, end := range group.endpoints {
+			if end.RPCMethod == "-" {
+				continue
+			}
+			rpcName := end.RPCMethod
+			if rpcName == "" {
+				rpcName = rpcMethodName(end.path)
+			}
+			name := strcase.UpperCamelCase(strings.ReplaceAll(rpcName, ".", "_"))
+			if name == "" || used[name] {
+				name += strcase.UpperCamelCase(strings.ToLower(group.method))
+			}
+			used[name] = true
+			route := end.path
+			if !strings.HasPrefix(route, "/") {
+				route = "/" + route
+			}
+			endpoints = append(endpoints, clientEndpoint{name: name, httpMethod: group.method, route: route, endpoint: end})
+		}
+	}
+	return endpoints
+}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/stoewer/go-strcase"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type computedField struct {
+	name       string
+	fieldName  string
+	fieldType  reflect.Type
+	function   func(item any) interface{}
+	expression interface{}
+}
+
+func (mi *ModelItem[model]) AddComputedField(name string, sample interface{}, fnc func(item model) interface{}) {
+	mi.computed = append(mi.computed, &computedField{
+		name:      name,
+		fieldName: strcase.UpperCamelCase(name),
+		fieldType: reflect.TypeOf(sample),
+		function: func(item any) interface{} {
+			return fnc(item.(model))
+		},
+	})
+}
+
+func (mi *ModelItem[model]) AddComputedExpression(name string, sample interface{}, expression interface{}) {
+	mi.computed = append(mi.computed, &computedField{
+		name:       name,
+		fieldName:  strcase.UpperCamelCase(name),
+		fieldType:  reflect.TypeOf(sample),
+		expression: expression,
+	})
+}
+
+func (mi *ModelItem[model]) computedStructFields() []reflect.StructField {
+	var fields []reflect.StructField
+	for _, item := range mi.computed {
+		tag := fmt.Sprintf(`json:"%s,omitempty" bson:"%s,omitempty" computed:"true"`, item.name, item.name)
+		if item.expression != nil {
+			tag += ` filter:"true"`
+		}
+		fields = append(fields, reflect.StructField{
+			Name: item.fieldName,
+			Type: item.fieldType,
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+	return fields
+}
+
+func (mi *ModelItem[model]) computedExpressions() M {
+	fields := M{}
+	for _, item := range mi.computed {
+		if item.expression != nil {
+			fields[item.name] = item.expression
+		}
+	}
+	return fields
+}
+
+func (mi *ModelItem[model]) stripComputed(adata M) {
+	for _, item := range mi.computed {
+		delete(adata, item.name)
+	}
+}
+
+func (mi *ModelItem[model]) toModel(item reflect.Value) model {
+	var out model
+	copyValue(reflect.ValueOf(&out).Elem(), item)
+	return out
+}
+
+func (mi *ModelItem[model]) applyComputed(item reflect.Value) {
+	for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+		item = item.Elem()
+	}
+	var modelItem model
+	built := false
+	for _, comp := range mi.computed {
+		if comp.function == nil {
+			continue
+		}
+		if !built {
+			modelItem = mi.toModel(item)
+			built = true
+		}
+		value := reflect.ValueOf(comp.function(modelItem))
+		field := item.FieldByName(comp.fieldName)
+		if !value.IsValid() || !field.CanSet() {
+			continue
+		}
+		if value.Type().AssignableTo(field.Type()) {
+			field.Set(value)
+		} else if value.Type().ConvertibleTo(field.Type()) {
+			field.Set(value.Convert(field.Type()))
+		}
+	}
+}
+
+func parseFilterValue(fieldType reflect.Type, value string) (interface{}, error) {
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	}
+	if fieldType == reflect.TypeOf(primitive.ObjectID{}) {
+		return primitive.ObjectIDFromHex(value)
+	}
+	return value, nil
+}
+
+func isFilterType(t reflect.Type) bool {
+	if t == reflect.TypeOf(primitive.ObjectID{}) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFilterField(field reflect.StructField) bool {
+	if field.Tag.Get("computed") != "" {
+		return field.Tag.Get("filter") != ""
+	}
+	jname := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch jname {
+	case "", "-", "offset", "limit", "sort":
+		return false
+	}
+	return field.IsExported() && isFilterType(field.Type)
+}
+
+func filterFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		if isFilterField(t.Field(i)) {
+			names[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+		}
+	}
+	return names
+}
+
+func (mi *ModelItem[model]) typedFilters(lookup func(name string) string) (M, error) {
+	filters := M{}
+	pnm := mi.model.(reflect.Type)
+	for i := 0; i < pnm.NumField(); i++ {
+		field := pnm.Field(i)
+		jname := strings.Split(field.Tag.Get("json"), ",")[0]
+		bname := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if !isFilterField(field) || jname == "" || bname == "" {
+			continue
+		}
+		value := lookup(jname)
+		if value == "" {
+			continue
+		}
+		typed, err := parseFilterValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %w", jname, err)
+		}
+		filters[bname] = typed
+	}
+	return filters, nil
+}
+
+func (mi *ModelItem[model]) querySort(sort string) (M, error) {
+	if sort == "" {
+		return nil, nil
+	}
+	order := M{}
+	keys := []string{}
+	pnm := mi.model.(reflect.Type)
+	for _, item := range strings.Split(sort, ",") {
+		direction := 1
+		if strings.HasPrefix(item, "-") {
+			direction = -1
+			item = item[1:]
+		}
+		found := false
+		for i := 0; i < pnm.NumField(); i++ {
+			field := pnm.Field(i)
+			if field.Tag.Get("filter") == "" || strings.Split(field.Tag.Get("json"), ",")[0] != item {
+				continue
+			}
+			key := strings.Split(field.Tag.Get("bson"), ",")[0]
+			order[key] = direction
+			keys = append(keys, key)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("sort %s is not supported", item)
+		}
+	}
+	if len(keys) > 1 {
+		return nil, fmt.Errorf("sort supports a single field")
+	}
+	return order, nil
+}
+
+func mergeFilters(query M, filters M) M {
+	merged := copyM(query)
+	for key, val := range filters {
+		if _, ok := merged[key]; !ok {
+			merged[key] = val
+		}
+	}
+	return merged
+}
+
+func (mi *ModelItem[model]) countFiltered(ctx context.Context, query M, computedFilters M) (int64, error) {
+	expressions := mi.computedExpressions()
+	if len(expressions) == 0 {
+		return mi.colDb.Count(ctx, mergeFilters(query, computedFilters))
+	}
+	pipeline := []M{
+		{"$match": query},
+		{"$addFields": expressions},
+	}
+	if len(computedFilters) > 0 {
+		pipeline = append(pipeline, M{"$match": computedFilters})
+	}
+	pipeline = append(pipeline, M{"$count": "total"})
+	cursor, err := mi.colDb.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var result []struct {
+		Total int64 `bson:"total"`
+	}
+	err = cursor.All(ctx, &result)
+	if err != nil || len(result) == 0 {
+		return 0, err
+	}
+	return result[0].Total, nil
+}
+
+func (mi *ModelItem[model]) findCursor(ctx context.Context, query M, computedFilters M, sort M, skip int64, limit int64) (Cursor, error) {
+	expressions := mi.computedExpressions()
+	if len(expressions) == 0 {
+		return mi.colDb.Find(ctx, mergeFilters(query, computedFilters), FindOptions{Sort: sort, Skip: skip, Limit: limit})
+	}
+	pipeline := []M{
+		{"$match": query},
+		{"$addFields": expressions},
+	}
+	if len(computedFilters) > 0 {
+		pipeline = append(pipeline, M{"$match": computedFilters})
+	}
+	if sort != nil {
+		pipeline = append(pipeline, M{"$sort": sort})
+	}
+	if skip > 0 {
+		pipeline = append(pipeline, M{"$skip": skip})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, M{"$limit": limit})
+	}
+	return mi.colDb.Aggregate(ctx, pipeline)
+}
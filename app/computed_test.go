@@ -0,0 +1,84 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Product struct {
+	Name  string
+	Price int64
+	Stock int64
+}
+
+type productList struct {
+	Total int64 `json:"total"`
+	Items []struct {
+		Name  string `json:"name"`
+		Value int64  `json:"value"`
+	} `json:"items"`
+}
+
+func productHarness(t *testing.T) *apptest.Client {
+	h := apptest.New(t)
+	products := app.NewModel[Product]("product")
+	products.AddComputedExpression("value", int64(0), app.M{"$multiply": []interface{}{"$price", "$stock"}})
+	products.Watchable = true
+	h.Register(products)
+	client := h.As(app.M{})
+	client.Post("/api/product/", app.M{"name": "pen", "price": 2, "stock": 10}).AssertStatus(201)
+	client.Post("/api/product/", app.M{"name": "ink", "price": 5, "stock": 1}).AssertStatus(201)
+	return client
+}
+
+func TestListFiltersOnComputedExpression(t *testing.T) {
+	client := productHarness(t)
+
+	var list productList
+	client.Get("/api/product/?value=20").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 || list.Items[0].Name != "pen" {
+		t.Fatalf("expected only pen, got %+v", list.Items)
+	}
+	client.Get("/api/product/?sort=-value").AssertStatus(200).Result(&list)
+	if len(list.Items) != 2 || list.Items[0].Name != "pen" {
+		t.Fatalf("expected pen first, got %+v", list.Items)
+	}
+}
+
+func TestListFiltersOnStoredFields(t *testing.T) {
+	client := productHarness(t)
+
+	var list productList
+	client.Get("/api/product/?name=pen").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 || list.Items[0].Name != "pen" {
+		t.Fatalf("expected only pen, got %+v", list.Items)
+	}
+	client.Get("/api/product/?price=5").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 || list.Items[0].Name != "ink" || list.Total != 1 {
+		t.Fatalf("expected only ink, got %+v", list)
+	}
+	client.Get("/api/product/?price=abc").AssertStatus(400)
+	client.Get("/api/product/?sort=price").AssertStatus(400)
+}
+
+func TestListTotalCountsAllMatches(t *testing.T) {
+	client := productHarness(t)
+
+	var list productList
+	client.Get("/api/product/?limit=1").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 || list.Total != 2 {
+		t.Fatalf("expected one item of two, got %d items of %d", len(list.Items), list.Total)
+	}
+	client.Get("/api/product/?limit=1&value=5").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 || list.Total != 1 {
+		t.Fatalf("expected the total to use the filter, got %d items of %d", len(list.Items), list.Total)
+	}
+}
+
+func TestWatchRejectsComputedFilters(t *testing.T) {
+	client := productHarness(t)
+
+	client.Get("/api/product/_watch?value=20").AssertStatus(400)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type scopeContextKey struct{}
+
+func ScopedContext(c *fiber.Ctx) context.Context {
+	scope, _ := c.Locals("authQuery").(M)
+	return WithScope(TxContext(c), scope)
+}
+
+func WithScope(ctx context.Context, scope M) context.Context {
+	if scope == nil {
+		scope = M{}
+	}
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+func contextScope(ctx context.Context) (M, bool) {
+	scope, ok := ctx.Value(scopeContextKey{}).(M)
+	return scope, ok
+}
+
+func (app *App) scopedQuery(ctx context.Context, collection string, query M) (M, error) {
+	scope, ok := contextScope(ctx)
+	if !ok {
+		return query, nil
+	}
+	if field, ok := app.tenantFields[collection]; ok {
+		if _, ok := scope[field]; !ok {
+			return nil, ErrTenantScope
+		}
+	}
+	out := M{}
+	for key, val := range query {
+		out[key] = val
+	}
+	for key, val := range scope {
+		out[key] = val
+	}
+	return out, nil
+}
+
+func (app *App) scopedPipeline(ctx context.Context, collection string, pipeline []M) ([]M, error) {
+	scope, ok := contextScope(ctx)
+	if !ok {
+		return pipeline, nil
+	}
+	match, err := app.scopedQuery(ctx, collection, M{})
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err = lookupScope{collections: app.tenantFields, tenantField: app.tenantFields[collection]}.scopeLookups(pipeline, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(match) == 0 {
+		return pipeline, nil
+	}
+	return append([]M{{"$match": match}}, pipeline...), nil
+}
+
+func (app *App) FindCollection(ctx context.Context, collection string, query M) (Cursor, error) {
+	query, err := app.scopedQuery(ctx, collection, query)
+	if err != nil {
+		return nil, err
+	}
+	return app.store.Collection(collection).Find(ctx, query, FindOptions{})
+}
+
+func (app *App) FindOneCollection(ctx context.Context, collection string, query M) (bson.Raw, error) {
+	query, err := app.scopedQuery(ctx, collection, query)
+	if err != nil {
+		return nil, err
+	}
+	return app.store.Collection(collection).FindOne(ctx, query)
+}
+
+func (app *App) AggrageteCollection(ctx context.Context, collection string, pipeline []M) (Cursor, error) {
+	pipeline, err := app.scopedPipeline(ctx, collection, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return app.store.Collection(collection).Aggregate(ctx, pipeline)
+}
+
+func FindMany[T any](ctx context.Context, app *App, collection string, query M, opt FindOptions) ([]T, error) {
+	query, err := app.scopedQuery(ctx, collection, query)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := app.store.Collection(collection).Find(ctx, query, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	items := []T{}
+	err = cursor.All(ctx, &items)
+	return items, err
+}
+
+func FindOne[T any](ctx context.Context, app *App, collection string, query M) (*T, error) {
+	raw, err := app.FindOneCollection(ctx, collection, query)
+	if err != nil {
+		return nil, err
+	}
+	item := new(T)
+	err = bson.Unmarshal(raw, item)
+	return item, err
+}
+
+func Aggregate[T any](ctx context.Context, app *App, collection string, pipeline []M) ([]T, error) {
+	cursor, err := app.AggrageteCollection(ctx, collection, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	items := []T{}
+	err = cursor.All(ctx, &items)
+	return items, err
+}
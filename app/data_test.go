@@ -0,0 +1,122 @@
+package app_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Shelf struct {
+	Title  string
+	Tenant string
+}
+
+type Book struct {
+	Title  string
+	Shelf  string
+	Tenant string
+}
+
+type shelfBooks struct {
+	Title string `json:"title" bson:"title"`
+	Books []Book `json:"books" bson:"books"`
+}
+
+var shelfBooksPipeline = []app.M{
+	{"$lookup": app.M{"from": "book", "localField": "title", "foreignField": "shelf", "as": "books"}},
+}
+
+func dataHarness(t *testing.T) *apptest.Harness {
+	h := apptest.New(t)
+	shelves := app.NewModel[Shelf]("shelf")
+	shelves.TenantField = "tenant"
+	books := app.NewModel[Book]("book")
+	books.TenantField = "tenant"
+	h.Register(shelves, books)
+	respond := func(c *fiber.Ctx, items any, err error) error {
+		if errors.Is(err, app.ErrTenantScope) {
+			return app.RError(c, 403, err.Error(), nil)
+		} else if err != nil {
+			return app.RError(c, 500, err.Error(), nil)
+		}
+		return app.ROk(c, 200, "", items)
+	}
+	h.App.RegisterGetEndpoint("/shelves", false, nil, nil, func(c *fiber.Ctx) error {
+		items, err := app.FindMany[Shelf](app.ScopedContext(c), h.App, "shelf", app.M{}, app.FindOptions{})
+		return respond(c, items, err)
+	})
+	h.App.RegisterGetEndpoint("/shelf_books", false, nil, nil, func(c *fiber.Ctx) error {
+		items, err := app.Aggregate[shelfBooks](app.ScopedContext(c), h.App, "shelf", shelfBooksPipeline)
+		return respond(c, items, err)
+	})
+	h.SeedCollection("shelf",
+		app.M{"title": "fiction", "tenant": "a"},
+		app.M{"title": "fiction", "tenant": "b"},
+		app.M{"title": "poetry", "tenant": "b"},
+	)
+	h.SeedCollection("book",
+		app.M{"title": "a novel", "shelf": "fiction", "tenant": "a"},
+		app.M{"title": "b novel", "shelf": "fiction", "tenant": "b"},
+	)
+	return h
+}
+
+func TestDataHelpersApplyScope(t *testing.T) {
+	h := dataHarness(t)
+
+	var shelves []Shelf
+	h.As(app.M{"tenant": "b"}).Get("/shelves").AssertStatus(200).Result(&shelves)
+	if len(shelves) != 2 || shelves[0].Tenant != "b" || shelves[1].Tenant != "b" {
+		t.Fatalf("expected tenant b's two shelves, got %+v", shelves)
+	}
+	h.As(app.M{}).Get("/shelves").AssertStatus(403)
+	h.As(app.M{}).Get("/shelf_books").AssertStatus(403)
+}
+
+func TestDataHelpersScopeLookups(t *testing.T) {
+	h := dataHarness(t)
+
+	var shelves []shelfBooks
+	h.As(app.M{"tenant": "a"}).Get("/shelf_books").AssertStatus(200).Result(&shelves)
+	if len(shelves) != 1 || len(shelves[0].Books) != 1 || shelves[0].Books[0].Title != "a novel" {
+		t.Fatalf("expected tenant a's shelf with only its book, got %+v", shelves)
+	}
+	if _, ok := shelfBooksPipeline[0]["$lookup"].(app.M)["pipeline"]; ok {
+		t.Fatal("expected the caller's pipeline to stay unchanged")
+	}
+}
+
+func TestDataHelpersConcurrentRequests(t *testing.T) {
+	h := dataHarness(t)
+	tenants := []string{"a", "b"}
+	clients := []*apptest.Client{h.As(app.M{"tenant": "a"}), h.As(app.M{"tenant": "b"})}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(tenant string, client *apptest.Client) {
+			defer wg.Done()
+			var shelves []shelfBooks
+			client.Get("/shelf_books").Result(&shelves)
+			for _, shelf := range shelves {
+				for _, book := range shelf.Books {
+					if book.Tenant != tenant {
+						errs <- fmt.Errorf("tenant %s got %+v", tenant, book)
+						return
+					}
+				}
+			}
+		}(tenants[i%2], clients[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
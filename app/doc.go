@@ -3,12 +3,18 @@ package app
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gosimple/slug"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var schemaNameCleaner = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
 type DocInfo struct {
 	Version string `json:"version"`
 	Title   string `json:"title"`
@@ -53,12 +59,14 @@ type PathDoc struct {
 }
 type DocMethodInfo struct {
 	Summary     string                 `json:"summary,omitempty"`
+	Description string                 `json:"description,omitempty"`
 	OperationID string                 `json:"operationId,omitempty"`
 	Tags        []string               `json:"tags,omitempty"`
 	Parameters  []*DocParameter        `json:"parameters,omitempty"`
 	Responses   map[string]DocResponse `json:"responses,omitempty"`
 	RequestBody *DocResponse           `json:"requestBody,omitempty"`
 	Security    []M                    `json:"security,omitempty"`
+	RateLimit   M                      `json:"x-ratelimit,omitempty"`
 }
 type DocEndPoint struct {
 	Put    *DocMethodInfo `json:"put,omitempty"`
@@ -72,49 +80,191 @@ type GenerateDoc struct {
 	schemas M
 	paths   M
 	data    M
+	rpcData M
 }
 
 func (gd *GenerateDoc) DocGenFieldData(mType reflect.Type) M {
 	mapData := M{}
-
+	for mType.Kind() == reflect.Ptr {
+		mType = mType.Elem()
+	}
 	lenField := mType.NumField()
 	for i := 0; i < lenField; i++ {
 		field := mType.Field(i)
 		fld := field.Tag
 		jtag := fld.Get("json")
-		if jtag != "-" && jtag != "" {
-			nname := strings.Split(jtag, ",")[0]
-			typeText := ""
-			typeFormat := ""
-			if len(field.Type.Name()) > 2 {
-				switch strings.ToLower(field.Type.Name()[:3]) {
-				case "int":
-					typeText = "integer"
-					typeFormat = field.Type.Name()
-				case "uin":
-					typeText = "integer"
-					typeFormat = field.Type.Name()
-				case "tim":
-					typeText = "string"
-					typeFormat = "isodatetime"
-				case "flo":
-					typeText = "number"
-					typeFormat = field.Type.Name()
-				default:
-					typeText = "string"
-					typeFormat = field.Type.Name()
+		if jtag == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && jtag == "" && fieldType.Kind() == reflect.Struct && !isLeafStruct(fieldType) {
+			for key, val := range gd.DocGenFieldData(fieldType) {
+				if _, ok := mapData[key]; !ok {
+					mapData[key] = val
 				}
-
 			}
-			mapData[nname] = M{
-				"type":   typeText,
-				"format": typeFormat,
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		nname := strings.Split(jtag, ",")[0]
+		if nname == "" {
+			nname = field.Name
+		}
+		schema := gd.DocSchema(field.Type)
+		if format := fld.Get("format"); format != "" {
+			schema["format"] = format
+		}
+		if fld.Get("computed") != "" {
+			schema["readOnly"] = true
+		}
+		mapData[nname] = schema
+	}
+	return mapData
+}
+func (gd *GenerateDoc) DocQueryData(mType reflect.Type) M {
+	mapData := M{}
+	for mType.Kind() == reflect.Ptr {
+		mType = mType.Elem()
+	}
+	for i := 0; i < mType.NumField(); i++ {
+		field := mType.Field(i)
+		qtag := strings.Split(field.Tag.Get("query"), ",")[0]
+		if qtag == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && qtag == "" && fieldType.Kind() == reflect.Struct && !isLeafStruct(fieldType) {
+			for key, val := range gd.DocQueryData(fieldType) {
+				if _, ok := mapData[key]; !ok {
+					mapData[key] = val
+				}
 			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if qtag == "" {
+			qtag = field.Name
+		}
+		mapData[qtag] = gd.DocSchema(field.Type)
+	}
+	return mapData
+}
 
+func docRequired(mType reflect.Type) []string {
+	var required []string
+	for mType.Kind() == reflect.Ptr {
+		mType = mType.Elem()
+	}
+	for i := 0; i < mType.NumField(); i++ {
+		field := mType.Field(i)
+		jtag := field.Tag.Get("json")
+		if jtag == "-" {
+			continue
 		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && jtag == "" && fieldType.Kind() == reflect.Struct && !isLeafStruct(fieldType) {
+			required = append(required, docRequired(fieldType)...)
+			continue
+		}
+		parts := strings.Split(jtag, ",")
+		if !field.IsExported() || field.Tag.Get("computed") != "" {
+			continue
+		}
+		omit := false
+		for _, opt := range parts[1:] {
+			omit = omit || opt == "omitempty"
+		}
+		if omit {
+			continue
+		}
+		if parts[0] == "" {
+			parts[0] = field.Name
+		}
+		required = append(required, parts[0])
+	}
+	sort.Strings(required)
+	return required
+}
 
+func (gd *GenerateDoc) objectSchema(t reflect.Type) M {
+	schema := M{
+		"type":       "object",
+		"properties": gd.DocGenFieldData(t),
 	}
-	return mapData
+	if required := docRequired(t); len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (gd *GenerateDoc) DocSchema(t reflect.Type) M {
+	switch t {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(primitive.DateTime(0)), reflect.TypeOf(primitive.Timestamp{}):
+		return M{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(primitive.ObjectID{}):
+		return M{"type": "string", "format": "objectid"}
+	case reflect.TypeOf(primitive.Decimal128{}):
+		return M{"type": "string", "format": "decimal"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := gd.DocSchema(t.Elem())
+		if ref, ok := schema["$ref"]; ok {
+			return M{"allOf": []M{{"$ref": ref}}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return M{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return M{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return M{"type": "integer", "format": "int32"}
+	case reflect.Float32:
+		return M{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return M{"type": "number", "format": "double"}
+	case reflect.String:
+		return M{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return M{"type": "string", "format": "byte"}
+		}
+		return M{"type": "array", "items": gd.DocSchema(t.Elem())}
+	case reflect.Map:
+		return M{"type": "object", "additionalProperties": gd.DocSchema(t.Elem())}
+	case reflect.Interface:
+		return M{}
+	case reflect.Struct:
+		if isLeafStruct(t) {
+			return M{"type": "string"}
+		}
+		named := originalType(t)
+		if named.Name() == "" {
+			return gd.objectSchema(t)
+		}
+		name := schemaNameCleaner.ReplaceAllString(named.Name(), "")
+		ref := M{"$ref": fmt.Sprintf("#/components/schemas/%s", name)}
+		if _, ok := gd.schemas[name]; !ok {
+			gd.schemas[name] = M{"type": "object"}
+			gd.schemas[name] = gd.objectSchema(t)
+		}
+		return ref
+	}
+	return M{"type": "string"}
 }
 func (gd *GenerateDoc) DocTags(mi ModelInterface) M {
 	item := mi.GetModelType()
@@ -149,6 +299,10 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 		}
 
 		if !isPost {
+			idType := "string"
+			if model.GetIdStrategy() == IdSequence {
+				idType = "integer"
+			}
 			parameters = append(parameters, &DocParameter{
 				Name:     "id",
 				In:       "path",
@@ -158,8 +312,8 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 					Maximum int    "json:\"maximum,omitempty\""
 					Format  string "json:\"format,omitempty\""
 				}{
-					Type:    "string",
-					Maximum: 24,
+					Type:   idType,
+					Format: idFormat(model.GetIdStrategy()),
 				},
 				Description: "The id needs for fetching",
 			})
@@ -183,7 +337,7 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 					Maximum int    "json:\"maximum,omitempty\""
 					Format  string "json:\"format,omitempty\""
 				}{
-					Type: val.(M)["type"].(string),
+					Type: docParamType(val),
 				},
 			})
 		}
@@ -194,11 +348,11 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 			},
 		}
 	}
+	if endpoint.Description != "" {
+		summary = endpoint.Description
+	}
 	if _, ok := gd.schemas[model.GetName()]; !ok {
-		gd.schemas[model.GetName()] = M{
-			"type":       "object",
-			"properties": gd.DocTags(model),
-		}
+		gd.schemas[model.GetName()] = gd.objectSchema(model.GetModelType().(reflect.Type))
 	}
 	if endpoint.IsAggregade {
 		if endpoint.Description != "" {
@@ -208,7 +362,7 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 		}
 		parameters = parameters[:0]
 		if endpoint.requestbody != nil {
-			data := gd.DocTagsCustom(endpoint.requestbody)
+			data := gd.DocQueryData(reflect.TypeOf(endpoint.requestbody))
 			for key, val := range data {
 				parameters = append(parameters, &DocParameter{
 					Name:     key,
@@ -219,7 +373,7 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 						Maximum int    "json:\"maximum,omitempty\""
 						Format  string "json:\"format,omitempty\""
 					}{
-						Type: val.(M)["type"].(string),
+						Type: docParamType(val),
 					},
 				})
 			}
@@ -230,10 +384,7 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 				kk = fmt.Sprintf("%s%s", kk, GenerateString(6))
 			}
 			ref := fmt.Sprintf("#/components/schemas/%s", kk)
-			gd.schemas[kk] = M{
-				"type":       "object",
-				"properties": gd.DocTagsCustom(endpoint.responseModel),
-			}
+			gd.schemas[kk] = gd.objectSchema(reflect.TypeOf(endpoint.responseModel))
 			responseBase = M{
 				"type": "array",
 				"items": M{
@@ -245,47 +396,12 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 	returnSchema := DocResponse{
 		Description: "Response",
 		Content: M{"application/json": M{
-			"schema": M{
-				"type": "object",
-				"properties": M{
-					"message": M{
-						"type": "string",
-					},
-					"status_code": M{
-						"type": "integer",
-					},
-					"status": M{
-						"type": "boolean",
-					},
-					"result": responseBase,
-				},
-			},
-		}},
-	}
-	notFoundResponse := DocResponse{
-		Description: "item not found",
-		Content: M{"application/json": M{
-			"schema": M{
-				"$ref": "#/components/schemas/NotFound",
-			},
-		}},
-	}
-	internalServerError := DocResponse{
-		Description: "internal server error",
-		Content: M{"application/json": M{
-			"schema": M{
-				"$ref": "#/components/schemas/ServerError",
-			},
-		}},
-	}
-	unauthorizedResponse := DocResponse{
-		Description: "Unauthorized",
-		Content: M{"application/json": M{
-			"schema": M{
-				"$ref": "#/components/schemas/Unauthorized",
-			},
+			"schema": gd.app.envelope().SuccessSchema(responseBase),
 		}},
 	}
+	notFoundResponse := gd.errorResponse("item not found", "NotFound")
+	internalServerError := gd.errorResponse("internal server error", "ServerError")
+	unauthorizedResponse := gd.errorResponse("Unauthorized", "Unauthorized")
 	resp := map[string]DocResponse{
 		"404": notFoundResponse,
 		"500": internalServerError,
@@ -326,6 +442,7 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 		Tags:       tags,
 		Security:   sec,
 	}
+	gd.DocRateLimit(method, endpoint)
 	if doc, ok := gd.paths[endpoint.docpath].(DocEndPoint); ok {
 		if isPost || isPut {
 			text := fmt.Sprintf("Create a new a %s", model.GetName())
@@ -374,33 +491,51 @@ func (gd *GenerateDoc) GenerateDocItem(model ModelInterface, endpoint *EndPoint,
 		gd.paths[endpoint.docpath] = endpointItem
 	}
 }
-func (gd *GenerateDoc) GenerateOtherEndpoints() {
-	allEndpoints := gd.app.GetEndPoints
-	allEndpoints = append(allEndpoints, gd.app.PostEndPoints...)
-	notFoundResponse := DocResponse{
-		Description: "item not found",
-		Content: M{"application/json": M{
-			"schema": M{
-				"$ref": "#/components/schemas/NotFound",
-			},
-		}},
+func (gd *GenerateDoc) DocRateLimit(method *DocMethodInfo, endpoint *EndPoint) {
+	limit := endpoint.RateLimit
+	if limit == nil || limit.Requests <= 0 || limit.Window <= 0 {
+		return
 	}
-	internalServerError := DocResponse{
-		Description: "internal server error",
-		Content: M{"application/json": M{
-			"schema": M{
-				"$ref": "#/components/schemas/ServerError",
-			},
-		}},
+	keyBy := limit.KeyBy
+	if keyBy == "" {
+		keyBy = RateLimitByIP
 	}
-	unauthorizedResponse := DocResponse{
-		Description: "Unauthorized",
-		Content: M{"application/json": M{
+	method.Description = fmt.Sprintf("Rate limited to %d requests per %s by %s.", limit.Requests, limit.Window, keyBy)
+	method.RateLimit = M{
+		"requests": limit.Requests,
+		"window":   int(limit.Window.Seconds()),
+		"key":      keyBy,
+	}
+	integerHeader := func(desc string) DocHeader {
+		header := DocHeader{Description: desc}
+		header.Schema.Type = "integer"
+		return header
+	}
+	tooManyRequests := gd.errorResponse("too many requests", "TooManyRequests")
+	tooManyRequests.Headers = map[string]DocHeader{
+		"RateLimit-Limit":     integerHeader("Request limit of the window"),
+		"RateLimit-Remaining": integerHeader("Remaining requests in the window"),
+		"RateLimit-Reset":     integerHeader("Seconds until the window resets"),
+		"Retry-After":         integerHeader("Seconds to wait before retrying"),
+	}
+	method.Responses["429"] = tooManyRequests
+}
+func (gd *GenerateDoc) errorResponse(description string, schema string) DocResponse {
+	return DocResponse{
+		Description: description,
+		Content: M{gd.app.envelope().ErrorContentType(): M{
 			"schema": M{
-				"$ref": "#/components/schemas/Unauthorized",
+				"$ref": fmt.Sprintf("#/components/schemas/%s", schema),
 			},
 		}},
 	}
+}
+func (gd *GenerateDoc) GenerateOtherEndpoints() {
+	allEndpoints := gd.app.GetEndPoints
+	allEndpoints = append(allEndpoints, gd.app.PostEndPoints...)
+	notFoundResponse := gd.errorResponse("item not found", "NotFound")
+	internalServerError := gd.errorResponse("internal server error", "ServerError")
+	unauthorizedResponse := gd.errorResponse("Unauthorized", "Unauthorized")
 	for _, endpoint := range allEndpoints {
 		summary := fmt.Sprintf("Returns a single %s", endpoint.Name)
 		if endpoint.Description != "" {
@@ -429,6 +564,7 @@ func (gd *GenerateDoc) GenerateOtherEndpoints() {
 			Tags:       tags,
 			Security:   sec,
 		}
+		gd.DocRateLimit(method, endpoint)
 		kk := slug.Make(endpoint.Name)
 		if endpoint.requestbody != nil {
 			if endpoint.IsPost {
@@ -453,10 +589,7 @@ func (gd *GenerateDoc) GenerateOtherEndpoints() {
 		}
 		if endpoint.responseModel != nil {
 			key := fmt.Sprintf("Response%s", kk)
-			gd.schemas[key] = M{
-				"type":       "object",
-				"properties": gd.DocTagsCustom(endpoint.responseModel),
-			}
+			gd.schemas[key] = gd.objectSchema(reflect.TypeOf(endpoint.responseModel))
 			ref := fmt.Sprintf("#/components/schemas/%s", key)
 			method.Responses["200"] = DocResponse{
 				Description: fmt.Sprintf("%s model", endpoint.Name),
@@ -507,44 +640,8 @@ func (gd *GenerateDoc) Generate() {
 
 	}
 	gd.GenerateOtherEndpoints()
-	gd.schemas["NotFound"] = M{
-		"type": "object",
-		"properties": M{
-			"message": M{
-				"type": "string",
-			},
-			"status_code": M{
-				"type": "integer",
-			},
-		},
-	}
-	gd.schemas["Unauthorized"] = M{
-		"type": "object",
-		"properties": M{
-			"message": M{
-				"type": "string",
-			},
-			"error": M{
-				"type": "string",
-			},
-			"status_code": M{
-				"type": "integer",
-			},
-		},
-	}
-	gd.schemas["ServerError"] = M{
-		"type": "object",
-		"properties": M{
-			"message": M{
-				"type": "string",
-			},
-			"error": M{
-				"type": "object",
-			},
-			"status_code": M{
-				"type": "integer",
-			},
-		},
+	for _, name := range []string{"NotFound", "Unauthorized", "TooManyRequests", "ServerError"} {
+		gd.schemas[name] = gd.app.envelope().ErrorSchema()
 	}
 	data := M{
 		"paths": gd.paths,
@@ -573,6 +670,9 @@ func (gd *GenerateDoc) Generate() {
 		},
 	}
 	gd.data = data
+	if gd.app.RPC {
+		gd.GenerateOpenRPC()
+	}
 
 }
 func (gd *GenerateDoc) ResponseUI(c *fiber.Ctx) error {
@@ -630,5 +730,15 @@ func NewDoc(app *App) *GenerateDoc {
 	doc.Generate()
 	app.RegisterGetEndpoint("/doc.json", true, nil, nil, doc.Response)
 	app.RegisterGetEndpoint("/doc/", true, nil, nil, doc.ResponseUI)
+	if app.RPC {
+		app.RegisterGetEndpoint("/openrpc.json", true, nil, nil, doc.OpenRPCResponse)
+	}
 	return doc
 }
+
+func docParamType(schema interface{}) string {
+	if typ, ok := schema.(M)["type"].(string); ok && typ != "array" && typ != "object" {
+		return typ
+	}
+	return "string"
+}
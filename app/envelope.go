@@ -0,0 +1,190 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gosimple/slug"
+)
+
+const envelopeKey = "envelope"
+
+const problemContentType = "application/problem+json"
+
+type Envelope interface {
+	Success(c *fiber.Ctx, code int, message string, data any) error
+	Failure(c *fiber.Ctx, code int, message string, data any) error
+	SuccessSchema(result M) M
+	ErrorSchema() M
+	ErrorContentType() string
+}
+
+type DefaultEnvelope struct{}
+
+func (DefaultEnvelope) Success(c *fiber.Ctx, code int, message string, data any) error {
+	return c.Status(code).JSON(Response{
+		Message:    message,
+		Status:     true,
+		StatusCode: code,
+		Result:     data,
+	})
+}
+
+func (DefaultEnvelope) Failure(c *fiber.Ctx, code int, message string, data any) error {
+	return c.Status(code).JSON(Response{
+		Message:    message,
+		Status:     false,
+		StatusCode: code,
+		Error:      data,
+	})
+}
+
+func (DefaultEnvelope) SuccessSchema(result M) M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"message":     M{"type": "string"},
+			"status_code": M{"type": "integer"},
+			"status":      M{"type": "boolean"},
+			"result":      result,
+		},
+	}
+}
+
+func (DefaultEnvelope) ErrorSchema() M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"message":     M{"type": "string"},
+			"status_code": M{"type": "integer"},
+			"status":      M{"type": "boolean"},
+			"error":       M{},
+		},
+	}
+}
+
+func (DefaultEnvelope) ErrorContentType() string {
+	return fiber.MIMEApplicationJSON
+}
+
+type BareEnvelope struct{}
+
+func (BareEnvelope) Success(c *fiber.Ctx, code int, message string, data any) error {
+	if data == nil {
+		return c.Status(code).JSON(M{"message": message})
+	}
+	return c.Status(code).JSON(data)
+}
+
+func (BareEnvelope) Failure(c *fiber.Ctx, code int, message string, data any) error {
+	body := M{"message": message}
+	if data != nil {
+		body["error"] = data
+	}
+	return c.Status(code).JSON(body)
+}
+
+func (BareEnvelope) SuccessSchema(result M) M {
+	return result
+}
+
+func (BareEnvelope) ErrorSchema() M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"message": M{"type": "string"},
+			"error":   M{},
+		},
+	}
+}
+
+func (BareEnvelope) ErrorContentType() string {
+	return fiber.MIMEApplicationJSON
+}
+
+type ProblemEnvelope struct {
+	TypeBase string
+	Bare     bool
+}
+
+func (pe ProblemEnvelope) successEnvelope() Envelope {
+	if pe.Bare {
+		return BareEnvelope{}
+	}
+	return DefaultEnvelope{}
+}
+
+func (pe ProblemEnvelope) Success(c *fiber.Ctx, code int, message string, data any) error {
+	return pe.successEnvelope().Success(c, code, message, data)
+}
+
+func (pe ProblemEnvelope) Failure(c *fiber.Ctx, code int, message string, data any) error {
+	problemType := "about:blank"
+	if pe.TypeBase != "" {
+		problemType = pe.TypeBase + "/" + slug.Make(http.StatusText(code))
+	}
+	body := M{
+		"type":     problemType,
+		"title":    http.StatusText(code),
+		"status":   code,
+		"detail":   message,
+		"instance": c.OriginalURL(),
+	}
+	if reqId, ok := c.UserContext().Value("request_id").(string); ok {
+		body["request_id"] = reqId
+	}
+	if data != nil {
+		body["errors"] = data
+	}
+	err := c.Status(code).JSON(body)
+	c.Set(fiber.HeaderContentType, problemContentType)
+	return err
+}
+
+func (pe ProblemEnvelope) SuccessSchema(result M) M {
+	return pe.successEnvelope().SuccessSchema(result)
+}
+
+func (ProblemEnvelope) ErrorSchema() M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"type":       M{"type": "string", "format": "uri"},
+			"title":      M{"type": "string"},
+			"status":     M{"type": "integer"},
+			"detail":     M{"type": "string"},
+			"instance":   M{"type": "string"},
+			"request_id": M{"type": "string"},
+			"errors":     M{},
+		},
+	}
+}
+
+func (ProblemEnvelope) ErrorContentType() string {
+	return problemContentType
+}
+
+func (app *App) envelope() Envelope {
+	if app.Envelope == nil {
+		if app.JSONAPI {
+			return app.jsonAPIEnvelope()
+		}
+		return DefaultEnvelope{}
+	}
+	return app.Envelope
+}
+
+func envelopeOf(c *fiber.Ctx) Envelope {
+	if env, ok := c.Locals(envelopeKey).(Envelope); ok {
+		return env
+	}
+	return DefaultEnvelope{}
+}
+
+func ROk(c *fiber.Ctx, code int, message string, data any) error {
+	return envelopeOf(c).Success(c, code, message, data)
+}
+
+func RError(c *fiber.Ctx, code int, message string, data any) error {
+	return envelopeOf(c).Failure(c, code, message, data)
+}
@@ -0,0 +1,35 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErrorHandlerUsesRequestEnvelope(t *testing.T) {
+	h := apptest.New(t)
+	h.App.RegisterGetEndpoint("/teapot", true, nil, nil, func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusTeapot, "short and stout")
+	})
+
+	var jsonAPI struct {
+		Errors []struct {
+			Status string `json:"status"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	h.Client().WithHeader(fiber.HeaderAccept, "application/vnd.api+json").Get("/teapot").AssertStatus(fiber.StatusTeapot).JSON(&jsonAPI)
+	if len(jsonAPI.Errors) != 1 || jsonAPI.Errors[0].Status != "418" || jsonAPI.Errors[0].Detail != "short and stout" {
+		t.Fatalf("expected a json:api error document, got %+v", jsonAPI)
+	}
+
+	var plain struct {
+		Message string `json:"message"`
+		Status  bool   `json:"status"`
+	}
+	h.Get("/teapot").AssertStatus(fiber.StatusTeapot).JSON(&plain)
+	if plain.Message != "short and stout" || plain.Status {
+		t.Fatalf("expected the default envelope, got %+v", plain)
+	}
+}
@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const outboxCollection = "fimapi_outbox"
+
+var changeEventTypes = map[string]string{
+	ChangeInsert:  EventCreated,
+	ChangeUpdate:  EventUpdated,
+	ChangeDelete:  EventDeleted,
+	ChangeRestore: EventRestored,
+}
+
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+)
+
+type Event struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	Type       string             `json:"type" bson:"type"`
+	Model      string             `json:"model" bson:"model"`
+	DocumentId string             `json:"document_id" bson:"document_id"`
+	Before     any                `json:"before,omitempty" bson:"before,omitempty"`
+	After      any                `json:"after,omitempty" bson:"after,omitempty"`
+	Actor      any                `json:"actor,omitempty" bson:"actor,omitempty"`
+	RequestId  string             `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Time       time.Time          `json:"time" bson:"time"`
+}
+
+type EventBus interface {
+	Publish(ctx context.Context, event *Event) error
+	Transactional() bool
+}
+
+type InProcessEventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(context.Context, *Event) error
+}
+
+func NewInProcessEventBus() *InProcessEventBus {
+	return &InProcessEventBus{
+		handlers: map[string][]func(context.Context, *Event) error{},
+	}
+}
+
+func (bus *InProcessEventBus) Subscribe(eventType string, fnc func(context.Context, *Event) error) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers[eventType] = append(bus.handlers[eventType], fnc)
+}
+
+func (bus *InProcessEventBus) Publish(ctx context.Context, event *Event) error {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for _, key := range []string{event.Type, "*"} {
+		for _, fnc := range bus.handlers[key] {
+			if err := fnc(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (bus *InProcessEventBus) Transactional() bool {
+	return false
+}
+
+type OutboxEventBus struct {
+	col *mongo.Collection
+}
+
+func NewOutboxEventBus(db *mongo.Database) *OutboxEventBus {
+	col := db.Collection(outboxCollection)
+	_, err := col.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: M{"published": 1, "time": 1},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &OutboxEventBus{col: col}
+}
+
+func (bus *OutboxEventBus) Publish(ctx context.Context, event *Event) error {
+	_, err := bus.col.InsertOne(ctx, struct {
+		*Event    `bson:",inline"`
+		Published bool `bson:"published"`
+	}{event, false})
+	return err
+}
+
+func (bus *OutboxEventBus) Transactional() bool {
+	return true
+}
+
+func (bus *OutboxEventBus) Pending(ctx context.Context, limit int64) ([]bson.Raw, error) {
+	opt := options.Find().SetSort(M{"time": 1}).SetLimit(limit)
+	cursor, err := bus.col.Find(ctx, M{"published": false}, opt)
+	if err != nil {
+		return nil, err
+	}
+	var events []bson.Raw
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (bus *OutboxEventBus) MarkPublished(ctx context.Context, id primitive.ObjectID) error {
+	_, err := bus.col.UpdateOne(ctx, M{"_id": id}, M{"$set": M{"published": true}})
+	return err
+}
+
+func (app *App) SetEventBus(bus EventBus) {
+	app.eventBus = bus
+	for i := range app.models {
+		app.models[i].SetEventBus(bus)
+	}
+}
+
+func (mi *ModelItem[model]) SetEventBus(bus EventBus) {
+	mi.bus = bus
+}
+
+func (mi *ModelItem[model]) decodeRaw(raw bson.Raw) any {
+	if raw == nil {
+		return nil
+	}
+	doc := reflect.New(mi.model.(reflect.Type)).Interface()
+	if bson.Unmarshal(raw, doc) != nil {
+		return nil
+	}
+	return doc
+}
+
+func (mi *ModelItem[model]) busEvent(c *fiber.Ctx, operation string, before bson.Raw, after bson.Raw) *Event {
+	event := &Event{
+		Id:     primitive.NewObjectID(),
+		Type:   changeEventTypes[operation],
+		Model:  mi.name,
+		Before: mi.decodeRaw(before),
+		After:  mi.decodeRaw(after),
+		Actor:  c.Locals("actor"),
+		Time:   time.Now(),
+	}
+	if event.Actor == nil {
+		event.Actor = c.Locals("authQuery")
+	}
+	if reqId, ok := c.UserContext().Value("request_id").(string); ok {
+		event.RequestId = reqId
+	}
+	raw := after
+	if raw == nil {
+		raw = before
+	}
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	if raw != nil && bson.Unmarshal(raw, &doc) == nil {
+		event.DocumentId = idString(doc.Id)
+	}
+	return event
+}
+
+func (mi *ModelItem[model]) SetLogger(logger *zap.Logger) {
+	mi.logger = logger
+}
+
+func (mi *ModelItem[model]) afterWrite(ctx context.Context, c *fiber.Ctx, operation string, before bson.Raw, after bson.Raw) error {
+	if mi.bus != nil {
+		err := mi.bus.Publish(ctx, mi.busEvent(c, operation, before, after))
+		if err != nil {
+			return err
+		}
+	}
+	return mi.runAfterHook(c, operation, before, after)
+}
+
+func (mi *ModelItem[model]) logWriteError(operation string, err error) {
+	if err != nil && mi.logger != nil {
+		mi.logger.Error("after write", zap.String("model", mi.name), zap.String("operation", operation), zap.Error(err))
+	}
+}
+
+func (mi *ModelItem[model]) runWrite(c *fiber.Ctx, operation string, fnc func(ctx context.Context) (bson.Raw, bson.Raw, error)) (bson.Raw, bson.Raw, error) {
+	var before, after bson.Raw
+	_, inTransaction := c.Locals(txContextKey).(context.Context)
+	transactional := inTransaction || mi.Transactional || (mi.bus != nil && mi.bus.Transactional())
+	outbox := mi.bus != nil && mi.bus.Transactional()
+	write := func(ctx context.Context) error {
+		var err error
+		before, after, err = fnc(ctx)
+		if err != nil || !transactional {
+			return err
+		}
+		if outbox {
+			err = mi.bus.Publish(ctx, mi.busEvent(c, operation, before, after))
+			if err != nil {
+				return err
+			}
+		}
+		return mi.runAfterHook(c, operation, before, after)
+	}
+	var err error
+	if transactional {
+		err = runTransaction(c, mi.store, write)
+	} else {
+		err = write(c.Context())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !transactional {
+		mi.logWriteError(operation, mi.afterWrite(c.Context(), c, operation, before, after))
+	}
+	afterCommit(c, func() {
+		if transactional && mi.bus != nil && !outbox {
+			mi.logWriteError(operation, mi.bus.Publish(c.Context(), mi.busEvent(c, operation, before, after)))
+		}
+		mi.invalidateCache()
+		if operation == ChangeDelete {
+			mi.publishChange(operation, before)
+		} else {
+			mi.publishChange(operation, after)
+		}
+	})
+	return before, after, nil
+}
+
+func (mi *ModelItem[model]) runAfterHook(c *fiber.Ctx, operation string, before bson.Raw, after bson.Raw) error {
+	var fnc func(item M, c *fiber.Ctx) error
+	raw := after
+	switch operation {
+	case ChangeInsert:
+		fnc = mi.AfterAddFunction
+	case ChangeUpdate, ChangeRestore:
+		fnc = mi.AfterUpdateFunction
+	case ChangeDelete:
+		fnc = mi.AfterDeleteFunction
+		raw = before
+	}
+	if fnc == nil {
+		return nil
+	}
+	var item M
+	if raw != nil {
+		err := bson.Unmarshal(raw, &item)
+		if err != nil {
+			return err
+		}
+	}
+	return fnc(item, c)
+}
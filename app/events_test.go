@@ -0,0 +1,61 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Invoice struct {
+	Number string
+}
+
+func TestFailingHookKeepsNonTransactionalWrite(t *testing.T) {
+	h := apptest.New(t)
+	invoices := app.NewModel[Invoice]("invoice")
+	invoices.AfterAdd(func(item app.M, c *fiber.Ctx) error {
+		return errors.New("mailer down")
+	})
+	h.Register(invoices)
+
+	h.As(app.M{}).Post("/api/invoice/", app.M{"number": "A-1"}).AssertStatus(201)
+	h.AssertEventCount("Invoice", app.EventCreated, 1)
+	if docs := h.Documents(invoices); len(docs) != 1 {
+		t.Fatalf("expected the invoice to be stored, got %d documents", len(docs))
+	}
+}
+
+func TestFailingBusKeepsNonTransactionalWrite(t *testing.T) {
+	h := apptest.New(t)
+	bus := app.NewInProcessEventBus()
+	bus.Subscribe("*", func(ctx context.Context, event *app.Event) error {
+		return errors.New("broker down")
+	})
+	h.Events.Next = bus
+	invoices := app.NewModel[Invoice]("invoice")
+	h.Register(invoices)
+
+	h.As(app.M{}).Post("/api/invoice/", app.M{"number": "A-1"}).AssertStatus(201)
+	if docs := h.Documents(invoices); len(docs) != 1 {
+		t.Fatalf("expected the invoice to be stored, got %d documents", len(docs))
+	}
+}
+
+func TestFailingHookRollsBackTransactionalWrite(t *testing.T) {
+	h := apptest.New(t)
+	invoices := app.NewModel[Invoice]("invoice")
+	invoices.Transactional = true
+	invoices.AfterAdd(func(item app.M, c *fiber.Ctx) error {
+		return errors.New("mailer down")
+	})
+	h.Register(invoices)
+
+	h.As(app.M{}).Post("/api/invoice/", app.M{"number": "A-1"}).AssertStatus(500)
+	if docs := h.Documents(invoices); len(docs) != 0 {
+		t.Fatalf("expected the insert to roll back, got %d documents", len(docs))
+	}
+}
@@ -0,0 +1,288 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stoewer/go-strcase"
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+)
+
+type fixtureRecord struct {
+	model  string
+	key    string
+	data   M
+	id     interface{}
+	loaded bool
+	active bool
+}
+
+type fixtureSet struct {
+	app     *App
+	ctx     *fiber.Ctx
+	records map[string]*fixtureRecord
+	order   []string
+}
+
+// UpsertFixture inserts data through the regular insert path. Only models with
+// NaturalKeys are upserted; an existing document with the same natural keys is
+// updated in place and insert hooks do not run again.
+func (mi *ModelItem[model]) UpsertFixture(c *fiber.Ctx, data M) (interface{}, error) {
+	bb, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	pnm := mi.model.(reflect.Type)
+	insertobj := reflect.New(pnm).Interface()
+	err = json.Unmarshal(bb, insertobj)
+	if err != nil {
+		return nil, err
+	}
+	bb, _ = json.Marshal(insertobj)
+	var adata M
+	json.Unmarshal(bb, &adata)
+	delete(adata, "id")
+	mi.stripComputed(adata)
+	delete(adata, "version")
+	for key, val := range data {
+		if oid, ok := val.(primitive.ObjectID); ok {
+			adata[key] = oid
+		}
+	}
+	filter := M{}
+	for _, key := range mi.NaturalKeys {
+		val, ok := adata[key]
+		if !ok {
+			return nil, fmt.Errorf("natural key %s missing", key)
+		}
+		filter[key] = val
+	}
+	var after bson.Raw
+	if len(filter) > 0 {
+		_, err = mi.colDb.FindOne(c.Context(), filter)
+	}
+	if len(filter) == 0 || err == ErrNoDocuments {
+		after, err = mi.insertDoc(c, adata, data["id"])
+	} else if err == nil {
+		if mi.SoftDelete {
+			adata["is_deleted"] = false
+		}
+		_, after, err = mi.runWrite(c, ChangeUpdate, func(ctx context.Context) (bson.Raw, bson.Raw, error) {
+			before, err := mi.colDb.FindOne(ctx, filter)
+			if err != nil {
+				return nil, nil, err
+			}
+			after, err := mi.colDb.Update(ctx, filter, mi.versionedUpdate(M{"$set": adata}), UpdateOptions{ReturnAfter: true})
+			return before, after, err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	err = bson.Unmarshal(after, &doc)
+	return doc.Id, err
+}
+
+func (app *App) findModel(name string) ModelInterface {
+	for _, item := range app.models {
+		if strings.EqualFold(item.GetName(), name) || strcase.SnakeCase(item.GetName()) == name {
+			return item
+		}
+	}
+	return nil
+}
+
+func (fs *fixtureSet) add(model string, key string, data M) error {
+	ref := model + "." + key
+	if _, ok := fs.records[ref]; ok {
+		return fmt.Errorf("fixture %s defined twice", ref)
+	}
+	fs.records[ref] = &fixtureRecord{model: model, key: key, data: data}
+	fs.order = append(fs.order, ref)
+	return nil
+}
+
+func (fs *fixtureSet) parse(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		scanner := bufio.NewScanner(strings.NewReader(string(content)))
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var item M
+			err = json.Unmarshal([]byte(text), &item)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			model, _ := item["_model"].(string)
+			key, _ := item["_key"].(string)
+			if model == "" || key == "" {
+				return fmt.Errorf("%s:%d: _model and _key are required", path, line)
+			}
+			delete(item, "_model")
+			delete(item, "_key")
+			err = fs.add(model, key, item)
+			if err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	case ".yaml", ".yml", ".json":
+		var items map[string]map[string]M
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			err = json.Unmarshal(content, &items)
+		} else {
+			err = yaml.Unmarshal(content, &items)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		models := make([]string, 0, len(items))
+		for model := range items {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			keys := make([]string, 0, len(items[model]))
+			for key := range items[model] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				err = fs.add(model, key, items[model][key])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: unsupported fixture format", path)
+}
+
+func (fs *fixtureSet) resolveValue(val interface{}) (interface{}, error) {
+	switch item := val.(type) {
+	case string:
+		if strings.HasPrefix(item, "@@") {
+			return item[1:], nil
+		}
+		if strings.HasPrefix(item, "@") {
+			record, ok := fs.records[item[1:]]
+			if !ok {
+				return nil, fmt.Errorf("unknown fixture reference %s", item)
+			}
+			err := fs.load(record)
+			if err != nil {
+				return nil, err
+			}
+			return record.id, nil
+		}
+	case map[string]interface{}:
+		for key, inner := range item {
+			resolved, err := fs.resolveValue(inner)
+			if err != nil {
+				return nil, err
+			}
+			item[key] = resolved
+		}
+	case M:
+		for key, inner := range item {
+			resolved, err := fs.resolveValue(inner)
+			if err != nil {
+				return nil, err
+			}
+			item[key] = resolved
+		}
+	case []interface{}:
+		for i := range item {
+			resolved, err := fs.resolveValue(item[i])
+			if err != nil {
+				return nil, err
+			}
+			item[i] = resolved
+		}
+	}
+	return val, nil
+}
+
+func (fs *fixtureSet) load(record *fixtureRecord) error {
+	if record.loaded {
+		return nil
+	}
+	if record.active {
+		return fmt.Errorf("fixture reference cycle at %s.%s", record.model, record.key)
+	}
+	record.active = true
+	defer func() { record.active = false }()
+	model := fs.app.findModel(record.model)
+	if model == nil {
+		return fmt.Errorf("fixture %s.%s: unknown model", record.model, record.key)
+	}
+	for key, val := range record.data {
+		resolved, err := fs.resolveValue(val)
+		if err != nil {
+			return fmt.Errorf("fixture %s.%s: %w", record.model, record.key, err)
+		}
+		record.data[key] = resolved
+	}
+	id, err := model.UpsertFixture(fs.ctx, record.data)
+	if err != nil {
+		return fmt.Errorf("fixture %s.%s: %w", record.model, record.key, err)
+	}
+	record.id = id
+	record.loaded = true
+	return nil
+}
+
+func (app *App) LoadFixtures(paths ...string) error {
+	if len(app.models) == 0 {
+		return errors.New("no registered models")
+	}
+	fapp := fiber.New()
+	ctx := fapp.AcquireCtx(&fasthttp.RequestCtx{})
+	defer fapp.ReleaseCtx(ctx)
+	ctx.SetUserContext(context.WithValue(context.Background(), "request_id", "fixture"))
+
+	fs := &fixtureSet{
+		app:     app,
+		ctx:     ctx,
+		records: map[string]*fixtureRecord{},
+	}
+	for _, path := range paths {
+		err := fs.parse(path)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ref := range fs.order {
+		err := fs.load(fs.records[ref])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
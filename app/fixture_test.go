@@ -0,0 +1,74 @@
+package app_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Currency struct {
+	Code  string
+	Title string
+	Slug  string
+}
+
+func writeFixture(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "fixtures.yaml")
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFixturesUpsertOnNaturalKeys(t *testing.T) {
+	h := apptest.New(t)
+	currencies := app.NewModel[Currency]("currency")
+	currencies.NaturalKeys = []string{"code"}
+	currencies.Versioned = true
+	added := 0
+	currencies.UpdateOnAdd(func(item app.M, c *fiber.Ctx) (app.M, error) {
+		added++
+		item["slug"] = "added"
+		return item, nil
+	})
+	h.Register(currencies)
+	dir := t.TempDir()
+
+	err := h.App.LoadFixtures(writeFixture(t, dir, "currency:\n  euro:\n    code: EUR\n    title: Euro\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.App.LoadFixtures(writeFixture(t, dir, "currency:\n  euro:\n    code: EUR\n    title: Euro (EU)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := h.Documents(currencies)
+	if len(docs) != 1 {
+		t.Fatalf("expected the second load to update the fixture, got %v", docs)
+	}
+	if docs[0]["title"] != "Euro (EU)" || docs[0]["slug"] != "added" {
+		t.Fatalf("expected the update to keep insert-only fields, got %v", docs[0])
+	}
+	if docs[0]["version"] != int64(2) {
+		t.Fatalf("expected the update to bump the version, got %v", docs[0]["version"])
+	}
+	if added != 1 {
+		t.Fatalf("expected the insert hook to run once, got %d", added)
+	}
+}
+
+func TestFixturesWithoutNaturalKeysInsert(t *testing.T) {
+	h := apptest.New(t)
+	currencies := app.NewModel[Currency]("currency")
+	h.Register(currencies)
+
+	h.Seed(currencies, app.M{"code": "EUR"}, app.M{"code": "EUR"})
+	if docs := h.Documents(currencies); len(docs) != 2 {
+		t.Fatalf("expected fixtures without natural keys to be inserted, got %v", docs)
+	}
+}
@@ -0,0 +1,530 @@
+package app
+
+import (
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/stoewer/go-strcase"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type goClientGen struct {
+	app      *App
+	names    map[reflect.Type]string
+	used     map[string]bool
+	decls    []string
+	usesTime bool
+}
+
+func (g *goClientGen) uniqueName(name string) string {
+	name = schemaNameCleaner.ReplaceAllString(strcase.UpperCamelCase(name), "")
+	if name == "" {
+		name = "Type"
+	}
+	unique := name
+	for i := 2; g.used[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.used[unique] = true
+	return unique
+}
+
+func (g *goClientGen) declare(t reflect.Type, name string) string {
+	if known, ok := g.names[t]; ok {
+		return known
+	}
+	name = g.uniqueName(name)
+	g.names[t] = name
+	g.decls = append(g.decls, fmt.Sprintf("type %s %s\n", name, g.structBody(t)))
+	return name
+}
+
+func (g *goClientGen) goType(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(primitive.DateTime(0)):
+		g.usesTime = true
+		return "time.Time"
+	case reflect.TypeOf(primitive.ObjectID{}), reflect.TypeOf(primitive.Decimal128{}):
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.goType(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "[]byte"
+		}
+		return "[]" + g.goType(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.goType(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", g.goType(t.Key()), g.goType(t.Elem()))
+	case reflect.Interface:
+		return "any"
+	case reflect.Struct:
+		if isLeafStruct(t) {
+			return "json.RawMessage"
+		}
+		named := originalType(t)
+		if named.Name() == "" {
+			return g.structBody(t)
+		}
+		return g.declare(t, named.Name())
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return t.Kind().String()
+	}
+	return "json.RawMessage"
+}
+
+func (g *goClientGen) structFields(t reflect.Type) []string {
+	var lines []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jtag := field.Tag.Get("json")
+		if jtag == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && jtag == "" && fieldType.Kind() == reflect.Struct && !isLeafStruct(fieldType) {
+			lines = append(lines, g.structFields(fieldType)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if jtag == "" {
+			jtag = field.Name
+		}
+		tags := fmt.Sprintf("json:\"%s\"", jtag)
+		if qtag, ok := field.Tag.Lookup("query"); ok {
+			tags += fmt.Sprintf(" query:\"%s\"", qtag)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s `%s`", field.Name, g.goType(field.Type), tags))
+	}
+	return lines
+}
+
+func (g *goClientGen) structBody(t reflect.Type) string {
+	fields := g.structFields(t)
+	if len(fields) == 0 {
+		return "struct{}"
+	}
+	return "struct {\n" + strings.Join(fields, "\n") + "\n}"
+}
+
+func goClientPath(route string) (string, []string) {
+	var args []string
+	parts := []string{}
+	for _, part := range strings.Split(route, "/") {
+		if strings.HasPrefix(part, ":") {
+			arg := strcase.LowerCamelCase(strings.TrimRight(strings.TrimPrefix(part, ":"), "?+"))
+			args = append(args, arg)
+			parts = append(parts, fmt.Sprintf("\" + url.PathEscape(fmt.Sprint(%s)) + \"", arg))
+			continue
+		}
+		parts = append(parts, part)
+	}
+	path := "\"" + strings.Join(parts, "/") + "\""
+	return strings.ReplaceAll(path, " + \"\"", ""), args
+}
+
+func (g *goClientGen) endpointMethod(service string, item clientEndpoint) string {
+	end := item.endpoint
+	path, args := goClientPath(item.route)
+	params := []string{"ctx context.Context"}
+	for _, arg := range args {
+		params = append(params, arg+" string")
+	}
+	query := "nil"
+	body := "nil"
+	if end.requestbody != nil {
+		reqType := g.goType(reflect.TypeOf(end.requestbody))
+		if !strings.HasPrefix(reqType, "*") {
+			reqType = "*" + reqType
+		}
+		if item.httpMethod == "GET" {
+			params = append(params, "query "+reqType)
+			query = "structQuery(query)"
+		} else {
+			params = append(params, "body "+reqType)
+			body = "body"
+		}
+	}
+	out := "json.RawMessage"
+	if end.responseModel != nil {
+		out = g.goType(reflect.TypeOf(end.responseModel))
+		if end.IsAggregade {
+			out = "[]" + out
+		}
+	}
+	receiver := "c *Client"
+	client := "c"
+	if service != "" {
+		receiver = "s *" + service
+		client = "s.client"
+	}
+	return fmt.Sprintf(`func (%s) %s(%s) (%s, error) {
+	var out %s
+	err := %s.do(ctx, http.Method%s, %s, %s, %s, &out)
+	return out, err
+}
+`, receiver, item.name, strings.Join(params, ", "), out, out, client, strcase.UpperCamelCase(strings.ToLower(item.httpMethod)), path, query, body)
+}
+
+func (g *goClientGen) filterType(model ModelInterface, name string) string {
+	t := model.GetModelType().(reflect.Type)
+	fields := []string{"Offset int64", "Limit int64", "Sort string"}
+	query := []string{
+		"if f.Offset != 0 {\nq.Set(\"offset\", fmt.Sprint(f.Offset))\n}",
+		"if f.Limit != 0 {\nq.Set(\"limit\", fmt.Sprint(f.Limit))\n}",
+		"if f.Sort != \"\" {\nq.Set(\"sort\", f.Sort)\n}",
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jname := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !isFilterField(field) || jname == "" || field.Name == "Offset" || field.Name == "Limit" || field.Name == "Sort" {
+			continue
+		}
+		var goType string
+		switch field.Type.Kind() {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			goType = field.Type.Kind().String()
+		}
+		if field.Type == reflect.TypeOf(primitive.ObjectID{}) {
+			goType = "string"
+		}
+		if goType == "" {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%s *%s", field.Name, goType))
+		query = append(query, fmt.Sprintf("if f.%s != nil {\nq.Set(%q, fmt.Sprint(*f.%s))\n}", field.Name, jname, field.Name))
+	}
+	filter := g.uniqueName(name + "Filter")
+	g.decls = append(g.decls, fmt.Sprintf(`type %s struct {
+%s
+}
+
+func (f *%s) query() url.Values {
+	q := url.Values{}
+	if f == nil {
+		return q
+	}
+	%s
+	return q
+}
+`, filter, strings.Join(fields, "\n"), filter, strings.Join(query, "\n")))
+	return filter
+}
+
+func (g *goClientGen) modelService(model ModelInterface) (string, string) {
+	t := model.GetModelType().(reflect.Type)
+	name := g.names[t]
+	g.decls = append(g.decls, fmt.Sprintf("type %s %s\n", name, g.structBody(t)))
+	service := g.uniqueName(name + "Service")
+	path := strcase.SnakeCase(model.GetName())
+	route := "\"/api/" + path + "/\""
+	idType := "string"
+	if model.GetIdStrategy() == IdSequence {
+		idType = "int64"
+	}
+	itemRoute := route + " + url.PathEscape(fmt.Sprint(id))"
+	access := modelAccessOf(model)
+	var b strings.Builder
+	fmt.Fprintf(&b, "type %s struct {\nclient *Client\n}\n\n", service)
+	if access.List {
+		filter := g.filterType(model, name)
+		list := g.uniqueName(name + "List")
+		g.decls = append(g.decls, fmt.Sprintf("type %s struct {\nTotal int `json:\"total\"`\nStart int64 `json:\"start\"`\nItems []%s `json:\"items\"`\n}\n", list, name))
+		fmt.Fprintf(&b, `func (s *%s) List(ctx context.Context, filter *%s) (*%s, error) {
+	var out %s
+	err := s.client.do(ctx, http.MethodGet, %s, filter.query(), nil, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+`, service, filter, list, list, route)
+	}
+	single := func(method string, args string, httpMethod string, target string, body string) {
+		fmt.Fprintf(&b, `func (s *%s) %s(ctx context.Context%s) (*%s, error) {
+	var out %s
+	err := s.client.do(ctx, http.Method%s, %s, nil, %s, &out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+`, service, method, args, name, name, httpMethod, target, body)
+	}
+	if access.Get {
+		single("Get", ", id "+idType, "Get", itemRoute, "nil")
+	}
+	if access.Insert {
+		single("Create", ", item *"+name, "Post", route, "item")
+	}
+	if access.Update {
+		single("Update", fmt.Sprintf(", id %s, item *%s", idType, name), "Put", itemRoute, "item")
+	}
+	if access.Delete {
+		fmt.Fprintf(&b, `func (s *%s) Delete(ctx context.Context, id %s) error {
+	return s.client.do(ctx, http.MethodDelete, %s, nil, nil, nil)
+}
+
+`, service, idType, itemRoute)
+	}
+	if access.Restore {
+		single("Restore", ", id "+idType, "Post", itemRoute+" + \"/restore\"", "nil")
+	}
+	for _, item := range modelClientEndpoints(model) {
+		b.WriteString(g.endpointMethod(service, item))
+		b.WriteString("\n")
+	}
+	return service, b.String()
+}
+
+func (app *App) GenerateGoClient(pkg string) ([]byte, error) {
+	successMode, errorMode, err := clientEnvelopeModes(app)
+	if err != nil {
+		return nil, err
+	}
+	g := &goClientGen{
+		names: map[reflect.Type]string{},
+		used:  map[string]bool{"Client": true, "Error": true},
+	}
+	var services []string
+	var methods strings.Builder
+	serviceFields := map[string]string{}
+	for _, model := range app.models {
+		g.names[model.GetModelType().(reflect.Type)] = g.uniqueName(model.GetName())
+	}
+	for _, model := range app.models {
+		service, code := g.modelService(model)
+		field := schemaNameCleaner.ReplaceAllString(strcase.UpperCamelCase(model.GetName()), "")
+		services = append(services, field)
+		serviceFields[field] = service
+		methods.WriteString(code)
+	}
+	names := map[string]bool{}
+	for _, field := range services {
+		names[field] = true
+	}
+	for _, item := range app.clientEndpoints(names) {
+		methods.WriteString(g.endpointMethod("", item))
+		methods.WriteString("\n")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by go-fiber-mapi. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	b.WriteString("import (\n\"bytes\"\n\"context\"\n\"encoding/json\"\n\"fmt\"\n\"io\"\n\"net/http\"\n\"net/url\"\n\"reflect\"\n\"strings\"\n")
+	if g.usesTime {
+		b.WriteString("\"time\"\n")
+	}
+	b.WriteString(")\n\n")
+	b.WriteString("type Client struct {\nBaseURL string\nHTTPClient *http.Client\nToken string\nHeader http.Header\n")
+	for _, field := range services {
+		fmt.Fprintf(&b, "%s *%s\n", field, serviceFields[field])
+	}
+	b.WriteString("}\n\nfunc NewClient(baseURL string) *Client {\nc := &Client{\nBaseURL: strings.TrimRight(baseURL, \"/\"),\nHTTPClient: http.DefaultClient,\nHeader: http.Header{},\n}\n")
+	for _, field := range services {
+		fmt.Fprintf(&b, "c.%s = &%s{client: c}\n", field, serviceFields[field])
+	}
+	b.WriteString("return c\n}\n\n")
+	b.WriteString(goClientRuntime)
+	b.WriteString(goClientDecoders[successMode+"_result"])
+	b.WriteString(goClientDecoders[errorMode+"_error"])
+	for _, decl := range g.decls {
+		b.WriteString(decl)
+		b.WriteString("\n")
+	}
+	b.WriteString(methods.String())
+	return format.Source([]byte(b.String()))
+}
+
+func (app *App) WriteGoClient(dir string, pkg string) error {
+	source, err := app.GenerateGoClient(pkg)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "client.go"), source, 0644)
+}
+
+const goClientRuntime = `type Error struct {
+	StatusCode int
+	Message    string
+	Data       json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		bb, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bb)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeError(resp.StatusCode, data)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	result, err := decodeResult(data)
+	if err != nil || len(result) == 0 || string(result) == "null" {
+		return err
+	}
+	return json.Unmarshal(result, out)
+}
+
+func structQuery(value any) url.Values {
+	query := url.Values{}
+	val := reflect.Indirect(reflect.ValueOf(value))
+	if val.Kind() != reflect.Struct {
+		return query
+	}
+	addQueryFields(query, val)
+	return query
+}
+
+func addQueryFields(query url.Values, val reflect.Value) {
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		item := val.Field(i)
+		if field.Anonymous && field.Tag.Get("query") == "" && reflect.Indirect(item).Kind() == reflect.Struct {
+			if item.Kind() != reflect.Ptr || !item.IsNil() {
+				addQueryFields(query, reflect.Indirect(item))
+			}
+			continue
+		}
+		key := strings.Split(field.Tag.Get("query"), ",")[0]
+		if key == "-" || !field.IsExported() {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		if item.Kind() == reflect.Ptr {
+			if item.IsNil() {
+				continue
+			}
+			item = item.Elem()
+		}
+		if item.IsZero() {
+			continue
+		}
+		switch item.Kind() {
+		case reflect.Slice, reflect.Array:
+			if item.Kind() == reflect.Slice && item.Type().Elem().Kind() == reflect.Uint8 {
+				query.Set(key, string(item.Bytes()))
+				continue
+			}
+			for j := 0; j < item.Len(); j++ {
+				query.Add(key, queryValue(item.Index(j)))
+			}
+		default:
+			query.Set(key, queryValue(item))
+		}
+	}
+}
+
+func queryValue(item reflect.Value) string {
+	switch item.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Interface:
+		bb, _ := json.Marshal(item.Interface())
+		return string(bb)
+	}
+	return fmt.Sprint(item.Interface())
+}
+`
+
+const goClientMessageError = `func decodeError(status int, data []byte) error {
+	var body struct {
+		Message string          ` + "`json:\"message\"`" + `
+		Error   json.RawMessage ` + "`json:\"error\"`" + `
+	}
+	json.Unmarshal(data, &body)
+	if body.Message == "" {
+		body.Message = http.StatusText(status)
+	}
+	return &Error{StatusCode: status, Message: body.Message, Data: body.Error}
+}
+
+`
+
+var goClientDecoders = map[string]string{
+	"envelope_result": `func decodeResult(data []byte) (json.RawMessage, error) {
+	var body struct {
+		Result json.RawMessage ` + "`json:\"result\"`" + `
+	}
+	err := json.Unmarshal(data, &body)
+	return body.Result, err
+}
+
+`,
+	"bare_result": `func decodeResult(data []byte) (json.RawMessage, error) {
+	return data, nil
+}
+
+`,
+	"envelope_error": goClientMessageError,
+	"bare_error":     goClientMessageError,
+	"problem_error": `func decodeError(status int, data []byte) error {
+	var body struct {
+		Detail string          ` + "`json:\"detail\"`" + `
+		Errors json.RawMessage ` + "`json:\"errors\"`" + `
+	}
+	json.Unmarshal(data, &body)
+	if body.Detail == "" {
+		body.Detail = http.StatusText(status)
+	}
+	return &Error{StatusCode: status, Message: body.Detail, Data: body.Errors}
+}
+
+`,
+}
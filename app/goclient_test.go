@@ -0,0 +1,133 @@
+package app_test
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Company struct {
+	Name      string
+	City      string
+	Employees int64
+}
+
+type CompanyName struct {
+	Name string `json:"name" bson:"name"`
+}
+
+type companyCity struct {
+	City string `json:"city_name" query:"city"`
+}
+
+const goClientMain = `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"clienttest/client"
+)
+
+func check(err error) {
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	ctx := context.Background()
+	c := client.NewClient(os.Args[1])
+	c.Token = os.Args[2]
+	for _, item := range []client.Company{{Name: "acme", City: "izmir", Employees: 5}, {Name: "globex", City: "ankara", Employees: 50}} {
+		_, err := c.Company.Create(ctx, &item)
+		check(err)
+	}
+	city := "ankara"
+	list, err := c.Company.List(ctx, &client.CompanyFilter{City: &city})
+	check(err)
+	if len(list.Items) != 1 || list.Total != 1 {
+		fmt.Println("unexpected list", list)
+		os.Exit(1)
+	}
+	id := list.Items[0].Id
+	_, err = c.Company.Update(ctx, id, &client.Company{Employees: 60})
+	check(err)
+	item, err := c.Company.Get(ctx, id)
+	check(err)
+	fmt.Println("get", item.Name, item.Employees)
+	names, err := c.Company.CompanyNames(ctx, &client.CompanyCity{City: "izmir"})
+	check(err)
+	fmt.Println("names", len(names), names[0].Name)
+	check(c.Company.Delete(ctx, id))
+	_, err = c.Company.Get(ctx, id)
+	if cerr, ok := err.(*client.Error); ok {
+		fmt.Println("deleted", cerr.StatusCode)
+	}
+}
+`
+
+func TestGeneratedGoClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated client with the go tool")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	h := apptest.New(t)
+	companies := app.NewModel[Company]("company")
+	companies.AddAggrageEndPoint("company_names", "get", CompanyName{}, companyCity{}, []app.M{
+		{"$match": app.M{"city": "{{.City}}"}},
+	})
+	h.Register(companies)
+	token := strings.TrimPrefix(h.As(app.M{}).Header().Get("Authorization"), "Bearer ")
+
+	source, err := h.App.GenerateGoClient("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":           "module clienttest\n\ngo 1.21\n",
+		"main.go":          goClientMain,
+		"client/client.go": string(source),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fapp := h.Fiber()
+	go fapp.Listener(ln)
+	t.Cleanup(func() {
+		fapp.Shutdown()
+	})
+
+	cmd := exec.Command(gobin, "run", ".", "http://"+ln.Addr().String(), token)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated client failed: %v\n%s", err, out)
+	}
+	want := "get globex 60\nnames 1 acme\ndeleted 404\n"
+	if string(out) != want {
+		t.Fatalf("expected %q, got %q", want, out)
+	}
+}
@@ -0,0 +1,680 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stoewer/go-strcase"
+)
+
+type graphQLRequestKey struct{}
+
+var graphQLNameCleaner = regexp.MustCompile(`[^_0-9A-Za-z]+`)
+
+type modelAccess struct {
+	Public  bool
+	Get     bool
+	List    bool
+	Insert  bool
+	Update  bool
+	Delete  bool
+	Restore bool
+}
+
+type graphQLModel interface {
+	GetName() string
+	GetModelType() interface{}
+	jsonAPIRelations() map[string]string
+	findByIds(c *fiber.Ctx, ids []string) ([]any, error)
+	findOne(c *fiber.Ctx, id string) (any, error)
+	findMany(c *fiber.Ctx, list ListQuery) (reflect.Value, error)
+	normalizeInput(data M) (M, error)
+	insertOne(c *fiber.Ctx, adata M) (any, error)
+	updateOne(c *fiber.Ctx, id string, adata M) (any, error)
+	deleteOne(c *fiber.Ctx, id string) error
+	restoreOne(c *fiber.Ctx, id string) (any, error)
+	access() modelAccess
+}
+
+type graphQLRequest struct {
+	c             *fiber.Ctx
+	authenticated bool
+	mu            sync.Mutex
+	loaders       map[string]*graphQLLoader
+}
+
+type graphQLLoader struct {
+	model   graphQLModel
+	pending map[string]bool
+	loaded  map[string]interface{}
+}
+
+type graphQLBuilder struct {
+	app     *App
+	models  map[string]graphQLModel
+	objects map[string]*graphql.Object
+	inputs  map[string]*graphql.InputObject
+}
+
+var graphQLJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: graphQLLiteral,
+})
+
+func graphQLLiteral(valueAST ast.Value) interface{} {
+	switch value := valueAST.(type) {
+	case *ast.ObjectValue:
+		out := M{}
+		for _, field := range value.Fields {
+			out[field.Name.Value] = graphQLLiteral(field.Value)
+		}
+		return out
+	case *ast.ListValue:
+		out := []interface{}{}
+		for _, item := range value.Values {
+			out = append(out, graphQLLiteral(item))
+		}
+		return out
+	}
+	return valueAST.GetValue()
+}
+
+func (mi *ModelItem[model]) access() modelAccess {
+	return modelAccess{
+		Public:  mi.IsPublic,
+		Get:     !mi.NoGet,
+		List:    !mi.NoList,
+		Insert:  !mi.NoInsert,
+		Update:  !mi.NoUpdate,
+		Delete:  !mi.NoDelete,
+		Restore: mi.SoftDelete && !mi.NoDelete,
+	}
+}
+
+func (e *OpError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.Data != nil {
+		ext["data"] = e.Data
+	}
+	return ext
+}
+
+func graphQLName(name string) string {
+	return graphQLNameCleaner.ReplaceAllString(strcase.UpperCamelCase(name), "")
+}
+
+func graphQLFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" || name == "" {
+		return ""
+	}
+	if graphQLNameCleaner.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+func graphQLValue(item any) (interface{}, error) {
+	bb, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(bb, &out)
+	return out, err
+}
+
+func graphQLRequestOf(p graphql.ResolveParams) *graphQLRequest {
+	return p.Context.Value(graphQLRequestKey{}).(*graphQLRequest)
+}
+
+func (req *graphQLRequest) allowed(model graphQLModel) error {
+	if model.access().Public || req.authenticated {
+		return nil
+	}
+	return opError(401, "Unauthorized", nil)
+}
+
+func (req *graphQLRequest) load(model graphQLModel, id string) func() (interface{}, error) {
+	req.mu.Lock()
+	loader, ok := req.loaders[model.GetName()]
+	if !ok {
+		loader = &graphQLLoader{model: model, pending: map[string]bool{}, loaded: map[string]interface{}{}}
+		req.loaders[model.GetName()] = loader
+	}
+	if _, ok := loader.loaded[id]; !ok {
+		loader.pending[id] = true
+	}
+	req.mu.Unlock()
+	return func() (interface{}, error) {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		if len(loader.pending) > 0 {
+			ids := make([]string, 0, len(loader.pending))
+			for pending := range loader.pending {
+				ids = append(ids, pending)
+				loader.loaded[pending] = nil
+			}
+			loader.pending = map[string]bool{}
+			items, err := model.findByIds(req.c, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				value, err := graphQLValue(item)
+				if err != nil {
+					return nil, err
+				}
+				if data, ok := value.(map[string]interface{}); ok {
+					loader.loaded[jsonAPIValue(data["id"])] = data
+				}
+			}
+		}
+		return loader.loaded[id], nil
+	}
+}
+
+func (gb *graphQLBuilder) outputType(t reflect.Type, name string) graphql.Output {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return graphql.Int
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	case reflect.String:
+		return graphql.String
+	case reflect.Slice, reflect.Array:
+		if isLeafStruct(t) {
+			return graphql.ID
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return graphql.String
+		}
+		return graphql.NewList(gb.outputType(t.Elem(), name))
+	case reflect.Struct:
+		if isLeafStruct(t) {
+			return graphql.String
+		}
+		named := originalType(t)
+		if named.Name() != "" {
+			name = graphQLName(named.Name())
+		}
+		if object, ok := gb.objects[name]; ok {
+			return object
+		}
+		object := graphql.NewObject(graphql.ObjectConfig{
+			Name: name,
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				return gb.structFields(t, name, nil)
+			}),
+		})
+		gb.objects[name] = object
+		return object
+	}
+	return graphQLJSON
+}
+
+func (gb *graphQLBuilder) inputType(t reflect.Type, name string) graphql.Input {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if isLeafStruct(t) || t.Elem().Kind() == reflect.Uint8 {
+			return gb.outputType(t, name).(graphql.Input)
+		}
+		return graphql.NewList(gb.inputType(t.Elem(), name))
+	case reflect.Struct:
+		if isLeafStruct(t) {
+			return graphql.String
+		}
+		named := originalType(t)
+		if named.Name() != "" {
+			name = graphQLName(named.Name())
+		}
+		name = name + "Input"
+		if input, ok := gb.inputs[name]; ok {
+			return input
+		}
+		input := graphql.NewInputObject(graphql.InputObjectConfig{
+			Name: name,
+			Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+				return gb.inputFields(t, strings.TrimSuffix(name, "Input"))
+			}),
+		})
+		gb.inputs[name] = input
+		return input
+	}
+	if output, ok := gb.outputType(t, name).(graphql.Input); ok {
+		return output
+	}
+	return graphQLJSON
+}
+
+func (gb *graphQLBuilder) structFields(t reflect.Type, name string, relations map[string]string) graphql.Fields {
+	fields := graphql.Fields{}
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		names[graphQLFieldName(t.Field(i))] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fname := graphQLFieldName(field)
+		if fname == "" || !field.IsExported() {
+			continue
+		}
+		if fname == "id" {
+			fields[fname] = &graphql.Field{Type: graphql.ID}
+			continue
+		}
+		fields[fname] = &graphql.Field{
+			Type: gb.outputType(field.Type, name+graphQLName(field.Name)),
+		}
+		target, ok := gb.models[relations[fname]]
+		if !ok {
+			continue
+		}
+		idName := fname + "_id"
+		if !names[idName] {
+			fields[idName] = &graphql.Field{
+				Type:    gb.outputType(field.Type, name+graphQLName(field.Name)),
+				Resolve: graphQLSourceField(fname),
+			}
+		}
+		fields[fname] = gb.referenceField(fname, field.Type, target)
+	}
+	return fields
+}
+
+func graphQLSourceField(name string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if source, ok := p.Source.(map[string]interface{}); ok {
+			return source[name], nil
+		}
+		return nil, nil
+	}
+}
+
+func (gb *graphQLBuilder) referenceField(name string, t reflect.Type, target graphQLModel) *graphql.Field {
+	object := gb.modelObject(target)
+	isList := t.Kind() == reflect.Slice && !isLeafStruct(t)
+	var output graphql.Output = object
+	if isList {
+		output = graphql.NewList(object)
+	}
+	return &graphql.Field{
+		Type: output,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			req := graphQLRequestOf(p)
+			if err := req.allowed(target); err != nil {
+				return nil, err
+			}
+			source, _ := p.Source.(map[string]interface{})
+			value := source[name]
+			if value == nil {
+				return nil, nil
+			}
+			if !isList {
+				return req.load(target, jsonAPIValue(value)), nil
+			}
+			list, _ := value.([]interface{})
+			thunks := []func() (interface{}, error){}
+			for _, id := range list {
+				thunks = append(thunks, req.load(target, jsonAPIValue(id)))
+			}
+			return func() (interface{}, error) {
+				items := []interface{}{}
+				for _, thunk := range thunks {
+					item, err := thunk()
+					if err != nil {
+						return nil, err
+					}
+					if item != nil {
+						items = append(items, item)
+					}
+				}
+				return items, nil
+			}, nil
+		},
+	}
+}
+
+func (gb *graphQLBuilder) inputFields(t reflect.Type, name string) graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fname := graphQLFieldName(field)
+		if fname == "" || !field.IsExported() || field.Tag.Get("computed") != "" {
+			continue
+		}
+		if fname == "id" {
+			fields[fname] = &graphql.InputObjectFieldConfig{Type: graphql.ID}
+			continue
+		}
+		fields[fname] = &graphql.InputObjectFieldConfig{
+			Type: gb.inputType(field.Type, name+graphQLName(field.Name)),
+		}
+	}
+	return fields
+}
+
+func (gb *graphQLBuilder) filterFields(t reflect.Type) graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fname := graphQLFieldName(field)
+		if fname == "" || !field.IsExported() || !isFilterField(field) {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Interface, reflect.Slice:
+			if !isLeafStruct(fieldType) {
+				continue
+			}
+		}
+		if input, ok := gb.outputType(fieldType, fname).(graphql.Input); ok {
+			fields[fname] = &graphql.InputObjectFieldConfig{Type: input}
+		}
+	}
+	return fields
+}
+
+func (gb *graphQLBuilder) modelObject(model graphQLModel) *graphql.Object {
+	name := graphQLName(model.GetName())
+	if object, ok := gb.objects[name]; ok {
+		return object
+	}
+	t := model.GetModelType().(reflect.Type)
+	relations := model.jsonAPIRelations()
+	object := graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return gb.structFields(t, name, relations)
+		}),
+	})
+	gb.objects[name] = object
+	return object
+}
+
+func graphQLResolver(model graphQLModel, fnc func(req *graphQLRequest, p graphql.ResolveParams) (any, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		req := graphQLRequestOf(p)
+		if err := req.allowed(model); err != nil {
+			return nil, err
+		}
+		item, err := fnc(req, p)
+		if err != nil || item == nil {
+			return nil, err
+		}
+		if value, ok := item.(reflect.Value); ok {
+			item = value.Interface()
+		}
+		return graphQLValue(item)
+	}
+}
+
+func (gb *graphQLBuilder) addModel(model graphQLModel, query graphql.Fields, mutation graphql.Fields) {
+	access := model.access()
+	name := graphQLName(model.GetName())
+	field := strcase.LowerCamelCase(name)
+	object := gb.modelObject(model)
+	t := model.GetModelType().(reflect.Type)
+	input := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: name + "Input",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			return gb.inputFields(t, name)
+		}),
+	})
+	gb.inputs[name+"Input"] = input
+	filter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: name + "Filter",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			return gb.filterFields(t)
+		}),
+	})
+	idArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+	}
+	if access.Get {
+		query[field] = &graphql.Field{
+			Type: object,
+			Args: idArgs,
+			Resolve: graphQLResolver(model, func(req *graphQLRequest, p graphql.ResolveParams) (any, error) {
+				return model.findOne(req.c, fmt.Sprint(p.Args["id"]))
+			}),
+		}
+	}
+	if access.List {
+		listArgs := graphql.FieldConfigArgument{
+			"offset": &graphql.ArgumentConfig{Type: graphql.Int},
+			"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+			"sort":   &graphql.ArgumentConfig{Type: graphql.String},
+		}
+		if len(filterFieldNames(t)) > 0 {
+			listArgs["filter"] = &graphql.ArgumentConfig{Type: filter}
+		}
+		query[field+"List"] = &graphql.Field{
+			Type: graphql.NewList(object),
+			Args: listArgs,
+			Resolve: graphQLResolver(model, func(req *graphQLRequest, p graphql.ResolveParams) (any, error) {
+				list := ListQuery{Filters: map[string]string{}}
+				if offset, ok := p.Args["offset"].(int); ok {
+					list.Offset = int64(offset)
+				}
+				if limit, ok := p.Args["limit"].(int); ok {
+					list.Limit = int64(limit)
+				}
+				list.Sort, _ = p.Args["sort"].(string)
+				if filters, ok := p.Args["filter"].(map[string]interface{}); ok {
+					for key, val := range filters {
+						if val != nil {
+							list.Filters[key] = fmt.Sprint(val)
+						}
+					}
+				}
+				return model.findMany(req.c, list)
+			}),
+		}
+	}
+	if access.Insert {
+		mutation["create"+name] = &graphql.Field{
+			Type: object,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: graphQLResolver(model, func(req *graphQLRequest, p graphql.ResolveParams) (any, error) {
+				adata, err := model.normalizeInput(p.Args["input"].(map[string]interface{}))
+				if err != nil {
+					return nil, err
+				}
+				return model.insertOne(req.c, adata)
+			}),
+		}
+	}
+	if access.Update {
+		mutation["update"+name] = &graphql.Field{
+			Type: object,
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)},
+			},
+			Resolve: graphQLResolver(model, func(req *graphQLRequest, p graphql.ResolveParams) (any, error) {
+				adata, err := model.normalizeInput(p.Args["input"].(map[string]interface{}))
+				if err != nil {
+					return nil, err
+				}
+				return model.updateOne(req.c, fmt.Sprint(p.Args["id"]), adata)
+			}),
+		}
+	}
+	if access.Delete {
+		mutation["delete"+name] = &graphql.Field{
+			Type: graphql.Boolean,
+			Args: idArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				req := graphQLRequestOf(p)
+				if err := req.allowed(model); err != nil {
+					return nil, err
+				}
+				err := model.deleteOne(req.c, fmt.Sprint(p.Args["id"]))
+				return err == nil, err
+			},
+		}
+	}
+	if access.Restore {
+		mutation["restore"+name] = &graphql.Field{
+			Type: object,
+			Args: idArgs,
+			Resolve: graphQLResolver(model, func(req *graphQLRequest, p graphql.ResolveParams) (any, error) {
+				return model.restoreOne(req.c, fmt.Sprint(p.Args["id"]))
+			}),
+		}
+	}
+}
+
+func (app *App) GraphQLSchema() (graphql.Schema, error) {
+	gb := &graphQLBuilder{
+		app:     app,
+		models:  map[string]graphQLModel{},
+		objects: map[string]*graphql.Object{},
+		inputs:  map[string]*graphql.InputObject{},
+	}
+	for _, item := range app.models {
+		if model, ok := item.(graphQLModel); ok {
+			gb.models[item.GetName()] = model
+			gb.models[strcase.SnakeCase(item.GetName())] = model
+		}
+	}
+	query := graphql.Fields{}
+	mutation := graphql.Fields{}
+	for _, item := range app.models {
+		if model, ok := item.(graphQLModel); ok {
+			gb.addModel(model, query, mutation)
+		}
+	}
+	if len(query) == 0 {
+		return graphql.Schema{}, fmt.Errorf("graphql schema has no queries")
+	}
+	config := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+	}
+	if len(mutation) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutation})
+	}
+	return graphql.NewSchema(config)
+}
+
+func (app *App) graphQLHandler(schema graphql.Schema) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var params struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}
+		if c.Method() == fiber.MethodGet {
+			params.Query = c.Query("query")
+			params.OperationName = c.Query("operationName")
+			if variables := c.Query("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+					return RError(c, 400, "invalid variables", err.Error())
+				}
+			}
+		} else if err := json.Unmarshal(c.Body(), &params); err != nil {
+			return RError(c, 400, "body parse error", err.Error())
+		}
+		if params.Query == "" {
+			return RError(c, 400, "query required", nil)
+		}
+		if c.Method() == fiber.MethodGet && graphQLIsMutation(params.Query, params.OperationName) {
+			c.Set(fiber.HeaderAllow, fiber.MethodPost)
+			return RError(c, 405, "mutations require POST", nil)
+		}
+		req := &graphQLRequest{
+			c:       c,
+			loaders: map[string]*graphQLLoader{},
+		}
+		if app.authMiddleware == nil {
+			req.authenticated = true
+		} else if extraQuery, err := app.authMiddleware(c); err == nil {
+			c.Locals("authQuery", extraQuery)
+			req.authenticated = true
+		} else if c.Get(fiber.HeaderAuthorization) != "" || !app.hasPublicModels() {
+			return RError(c, 401, "Unauthorized", err.Error())
+		}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  params.Query,
+			VariableValues: params.Variables,
+			OperationName:  params.OperationName,
+			Context:        context.WithValue(c.UserContext(), graphQLRequestKey{}, req),
+		})
+		return c.JSON(result)
+	}
+}
+
+func (app *App) hasPublicModels() bool {
+	for _, item := range app.models {
+		if model, ok := item.(graphQLModel); ok && model.access().Public {
+			return true
+		}
+	}
+	return false
+}
+
+func graphQLIsMutation(query string, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName != "" && (op.Name == nil || op.Name.Value != operationName) {
+			continue
+		}
+		if op.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) registerGraphQL() error {
+	schema, err := app.GraphQLSchema()
+	if err != nil {
+		return err
+	}
+	end := app.RegisterPostEndpoint("/graphql", true, nil, nil, app.graphQLHandler(schema))
+	end.Description = "GraphQL endpoint for registered models"
+	end.RPCMethod = "-"
+	end = app.RegisterGetEndpoint("/graphql", true, nil, nil, app.graphQLHandler(schema))
+	end.RPCMethod = "-"
+	return nil
+}
@@ -0,0 +1,94 @@
+package app_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Memo struct {
+	Text string
+}
+
+func TestGraphQLMutationsRequirePost(t *testing.T) {
+	h := apptest.New(t)
+	h.App.GraphQL = true
+	memos := app.NewModel[Memo]("memo")
+	h.Register(memos)
+	client := h.As(app.M{})
+
+	mutation := `mutation Add { createMemo(input: {text: "hi"}) { text } }`
+	get := url.Values{"query": {mutation}}
+	client.Get("/graphql?" + get.Encode()).AssertStatus(405)
+	get.Set("query", "query List { memoList { text } } "+mutation)
+	get.Set("operationName", "Add")
+	client.Get("/graphql?" + get.Encode()).AssertStatus(405)
+	if docs := h.Documents(memos); len(docs) != 0 {
+		t.Fatalf("expected no writes over GET, got %v", docs)
+	}
+
+	get.Set("operationName", "List")
+	client.Get("/graphql?" + get.Encode()).AssertStatus(200)
+	client.Post("/graphql", app.M{"query": mutation}).AssertStatus(200)
+	if docs := h.Documents(memos); len(docs) != 1 {
+		t.Fatalf("expected mutation over POST to write, got %v", docs)
+	}
+}
+
+type Draft struct {
+	Text string
+}
+
+func TestGraphQLRejectsFailedAuthentication(t *testing.T) {
+	h := apptest.New(t)
+	h.App.GraphQL = true
+	h.Register(app.NewModel[Memo]("memo"))
+
+	query := app.M{"query": `{ memoList { text } }`}
+	h.Post("/graphql", query).AssertStatus(401)
+	h.Client().WithHeader("Authorization", "Bearer forged").Post("/graphql", query).AssertStatus(401)
+	h.As(app.M{}).Post("/graphql", query).AssertStatus(200)
+}
+
+func TestGraphQLAllowsAnonymousPublicModels(t *testing.T) {
+	h := apptest.New(t)
+	h.App.GraphQL = true
+	memos := app.NewModel[Memo]("memo")
+	memos.IsPublic = true
+	h.Register(memos)
+
+	query := app.M{"query": `{ memoList { text } }`}
+	h.Post("/graphql", query).AssertStatus(200)
+	h.Client().WithHeader("Authorization", "Bearer forged").Post("/graphql", query).AssertStatus(401)
+}
+
+func TestGraphQLRestoreMutation(t *testing.T) {
+	h := apptest.New(t)
+	h.App.GraphQL = true
+	drafts := app.NewModel[Draft]("draft")
+	drafts.SoftDelete = true
+	h.Register(drafts)
+	client := h.As(app.M{})
+
+	id := h.Seed(drafts, app.M{"text": "kept"})[0].(primitive.ObjectID).Hex()
+	client.Delete("/api/draft/" + id).AssertStatus(200)
+	client.Get("/api/draft/" + id).AssertStatus(404)
+
+	var result struct {
+		Data struct {
+			RestoreDraft struct {
+				Text string `json:"text"`
+			} `json:"restoreDraft"`
+		} `json:"data"`
+		Errors []interface{} `json:"errors"`
+	}
+	client.Post("/graphql", app.M{"query": `mutation { restoreDraft(id: "` + id + `") { text } }`}).AssertStatus(200).JSON(&result)
+	if len(result.Errors) != 0 || result.Data.RestoreDraft.Text != "kept" {
+		t.Fatalf("expected the restore mutation to return the draft, got %+v", result)
+	}
+	client.Get("/api/draft/" + id).AssertStatus(200)
+	h.AssertEventCount("Draft", app.EventRestored, 1)
+}
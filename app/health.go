@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HealthOk   = "ok"
+	HealthFail = "fail"
+)
+
+type HealthResult struct {
+	Status   string `json:"status"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                   `json:"status"`
+	Checks map[string]*HealthResult `json:"checks,omitempty"`
+}
+
+type healthCheck struct {
+	name    string
+	timeout time.Duration
+	fnc     func(ctx context.Context) error
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func (app *App) AddHealthCheck(name string, timeout time.Duration, fnc func(ctx context.Context) error) {
+	for _, item := range app.healthChecks {
+		if item.name == name {
+			panic("health check " + name + " already registered")
+		}
+	}
+	app.healthChecks = append(app.healthChecks, &healthCheck{name: name, timeout: timeout, fnc: fnc})
+}
+
+func (app *App) readinessChecks() []*healthCheck {
+	var checks []*healthCheck
+	if pinger, ok := app.store.(Pinger); ok {
+		checks = append(checks, &healthCheck{name: "database", fnc: pinger.Ping})
+	}
+	if app.dbCon != nil && len(app.migrations) > 0 {
+		checks = append(checks, &healthCheck{name: "migrations", fnc: app.migrationsApplied})
+	}
+	checks = append(checks, &healthCheck{name: "indexes", fnc: func(ctx context.Context) error {
+		if !app.indexesDone.Load() {
+			return errors.New("indexes not created")
+		}
+		return app.indexesErr
+	}})
+	return append(checks, app.healthChecks...)
+}
+
+func (app *App) migrationsApplied(ctx context.Context) error {
+	pending, err := app.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations pending", len(pending))
+	}
+	return nil
+}
+
+func runHealthCheck(ctx context.Context, check *healthCheck) *HealthResult {
+	timeout := check.timeout
+	if timeout <= 0 {
+		timeout = time.Second * 2
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.fnc(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := &HealthResult{Status: HealthOk, Duration: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = HealthFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (app *App) Readiness(ctx context.Context) *HealthReport {
+	report := &HealthReport{Status: HealthOk, Checks: map[string]*HealthResult{}}
+	if app.ShuttingDown() {
+		report.Status = HealthFail
+		report.Checks["shutdown"] = &HealthResult{Status: HealthFail, Error: "shutting down"}
+		return report
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range app.readinessChecks() {
+		wg.Add(1)
+		go func(check *healthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.name] = result
+			if result.Status != HealthOk {
+				report.Status = HealthFail
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+func (app *App) livenessHandler(c *fiber.Ctx) error {
+	return c.JSON(HealthReport{Status: HealthOk})
+}
+
+func (app *App) readinessHandler(c *fiber.Ctx) error {
+	report := app.Readiness(c.UserContext())
+	code := fiber.StatusOK
+	if report.Status != HealthOk {
+		code = fiber.StatusServiceUnavailable
+	}
+	return c.Status(code).JSON(report)
+}
@@ -22,3 +22,27 @@ func StringWithCharset(length int, charset string) string {
 func GenerateString(length int) string {
 	return StringWithCharset(length, charset)
 }
+
+func copyM(item M) M {
+	out := make(M, len(item))
+	for key, val := range item {
+		out[key] = copyAny(val)
+	}
+	return out
+}
+
+func copyAny(val interface{}) interface{} {
+	switch v := val.(type) {
+	case M:
+		return copyM(v)
+	case map[string]interface{}:
+		return map[string]interface{}(copyM(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = copyAny(v[i])
+		}
+		return out
+	}
+	return val
+}
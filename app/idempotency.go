@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const idempotencyCollection = "fimapi_idempotency"
+
+type IdempotencyRecord struct {
+	Id           string             `bson:"_id"`
+	Key          string             `bson:"key"`
+	Principal    string             `bson:"principal"`
+	BodyHash     string             `bson:"body_hash"`
+	Completed    bool               `bson:"completed"`
+	ResponseCode int                `bson:"response_code,omitempty"`
+	ContentType  string             `bson:"content_type,omitempty"`
+	Body         []byte             `bson:"body,omitempty"`
+	Date         primitive.DateTime `bson:"date"`
+}
+
+func (app *App) idempotencyLife() time.Duration {
+	if app.IdempotencyLife > 0 {
+		return app.IdempotencyLife
+	}
+	return time.Hour * 24
+}
+
+func (app *App) IdempotencyDbInit() error {
+	if app.dbCon == nil {
+		return nil
+	}
+	col := app.dbCon.Collection(idempotencyCollection)
+	duration := int32(app.idempotencyLife().Seconds())
+	_, err := col.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: M{"date": 1},
+		Options: &options.IndexOptions{
+			ExpireAfterSeconds: &duration,
+		},
+	})
+	return err
+}
+
+func (app *App) idempotencyPrincipal(c *fiber.Ctx) string {
+	if authQuery := c.Locals("authQuery"); authQuery != nil {
+		scope, _ := json.Marshal(authQuery)
+		return string(scope)
+	}
+	return c.IP()
+}
+
+func (app *App) idempotencyHandler(fnc func(*fiber.Ctx) error) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("Idempotency-Key")
+		if !app.Idempotency || key == "" {
+			return fnc(c)
+		}
+		principal := app.idempotencyPrincipal(c)
+		idHash := sha256.Sum256([]byte(key + "\x00" + principal))
+		requestHash := sha256.New()
+		requestHash.Write([]byte(c.Method() + " " + c.Route().Path + "\x00"))
+		requestHash.Write(c.Body())
+		now := time.Now()
+		record := IdempotencyRecord{
+			Id:        hex.EncodeToString(idHash[:]),
+			Key:       key,
+			Principal: principal,
+			BodyHash:  hex.EncodeToString(requestHash.Sum(nil)),
+			Date:      primitive.NewDateTimeFromTime(now),
+		}
+		col := app.store.Collection(idempotencyCollection)
+		_, err := col.Update(c.Context(),
+			M{"_id": record.Id, "date": M{"$lt": primitive.NewDateTimeFromTime(now.Add(-app.idempotencyLife()))}},
+			M{"$set": M{
+				"key":       record.Key,
+				"principal": record.Principal,
+				"body_hash": record.BodyHash,
+				"completed": false,
+				"date":      record.Date,
+			}, "$unset": M{"response_code": "", "content_type": "", "body": ""}},
+			UpdateOptions{Upsert: true},
+		)
+		if isDuplicateKey(err) {
+			raw, err := col.FindOne(c.Context(), M{"_id": record.Id})
+			if err != nil {
+				return err
+			}
+			var stored IdempotencyRecord
+			err = bson.Unmarshal(raw, &stored)
+			if err != nil {
+				return err
+			}
+			if stored.BodyHash != record.BodyHash {
+				return RError(c, fiber.StatusUnprocessableEntity, "idempotency key reused with a different request", nil)
+			}
+			if !stored.Completed {
+				return RError(c, fiber.StatusConflict, "request with this idempotency key is in progress", nil)
+			}
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.ResponseCode).Send(stored.Body)
+		}
+		if err != nil && err != ErrNoDocuments {
+			return err
+		}
+
+		release := func() {
+			col.Delete(context.Background(), M{"_id": record.Id})
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+		err = fnc(c)
+		code := c.Response().StatusCode()
+		if err != nil || code >= fiber.StatusInternalServerError {
+			release()
+			return err
+		}
+		_, err = col.Update(context.Background(), M{"_id": record.Id}, M{"$set": M{
+			"completed":     true,
+			"response_code": code,
+			"content_type":  string(c.Response().Header.ContentType()),
+			"body":          append([]byte(nil), c.Response().Body()...),
+		}}, UpdateOptions{})
+		if err != nil {
+			app.errorLogger.Error("idempotency record", zap.String("key", key), zap.Error(err))
+			release()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Payment struct {
+	Amount int64
+}
+
+type Refund struct {
+	Amount int64
+}
+
+func idempotencyHarness(t *testing.T) (*apptest.Harness, *apptest.Client) {
+	h := apptest.New(t)
+	h.App.Idempotency = true
+	h.Register(app.NewModel[Payment]("payment"), app.NewModel[Refund]("refund"))
+	return h, h.As(app.M{"account": "a"})
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	h, client := idempotencyHarness(t)
+	client = client.WithHeader("Idempotency-Key", "pay-1")
+
+	var first, second struct {
+		Id string `json:"id"`
+	}
+	client.Post("/api/payment/", app.M{"amount": 10}).AssertStatus(201).Result(&first)
+	resp := client.Post("/api/payment/", app.M{"amount": 10}).AssertStatus(201).Result(&second)
+	if resp.Header.Get("Idempotent-Replayed") != "true" || first.Id != second.Id {
+		t.Fatalf("expected the first response to be replayed, got %s", resp)
+	}
+	if docs := h.Store.Documents("payment"); len(docs) != 1 {
+		t.Fatalf("expected one payment, got %d", len(docs))
+	}
+	h.AssertEventCount("Payment", app.EventCreated, 1)
+
+	h.As(app.M{"account": "b"}).WithHeader("Idempotency-Key", "pay-1").Post("/api/payment/", app.M{"amount": 10}).AssertStatus(201)
+	if docs := h.Store.Documents("payment"); len(docs) != 2 {
+		t.Fatalf("expected keys to be scoped to the principal, got %d payments", len(docs))
+	}
+}
+
+func TestIdempotencyRejectsReuseForAnotherRequest(t *testing.T) {
+	h, client := idempotencyHarness(t)
+	client = client.WithHeader("Idempotency-Key", "op-1")
+
+	client.Post("/api/payment/", app.M{"amount": 10}).AssertStatus(201)
+	client.Post("/api/payment/", app.M{"amount": 20}).AssertStatus(422)
+	client.Post("/api/refund/", app.M{"amount": 10}).AssertStatus(422)
+	if docs := h.Store.Documents("refund"); len(docs) != 0 {
+		t.Fatalf("expected the refund not to run, got %v", docs)
+	}
+}
+
+type failingCompletionStore struct {
+	*apptest.MemoryStore
+}
+
+func (fs failingCompletionStore) Collection(name string) app.StoreCollection {
+	col := fs.MemoryStore.Collection(name)
+	if name != "fimapi_idempotency" {
+		return col
+	}
+	return failingCompletionCollection{col}
+}
+
+type failingCompletionCollection struct {
+	app.StoreCollection
+}
+
+func (fc failingCompletionCollection) Update(ctx context.Context, filter app.M, update app.M, opt app.UpdateOptions) (bson.Raw, error) {
+	if set, ok := update["$set"].(app.M); ok && set["completed"] == true {
+		return nil, errors.New("write failed")
+	}
+	return fc.StoreCollection.Update(ctx, filter, update, opt)
+}
+
+func TestIdempotencyReleasesKeyWhenCompletionFails(t *testing.T) {
+	store := failingCompletionStore{apptest.NewMemoryStore()}
+	dapp := app.NewWithStore(store, t.TempDir())
+	dapp.Idempotency = true
+	dapp.RegisterModel(app.NewModel[Payment]("payment"))
+	fapp := dapp.Build()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/payment/", strings.NewReader(`{"amount":10}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set("Idempotency-Key", "pay-2")
+		resp, err := fapp.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 201 {
+			t.Fatalf("expected the key to be released after a failed completion, got %d", resp.StatusCode)
+		}
+	}
+	if docs := store.Documents("fimapi_idempotency"); len(docs) != 0 {
+		t.Fatalf("expected no idempotency records, got %v", docs)
+	}
+}
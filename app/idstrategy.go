@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	IdObjectID = "objectid"
+	IdUUIDv4   = "uuid4"
+	IdUUIDv7   = "uuid7"
+	IdULID     = "ulid"
+	IdSequence = "sequence"
+	IdSlug     = "slug"
+	IdNatural  = "natural"
+)
+
+const counterCollection = "fimapi_counters"
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	ErrInvalidId = errors.New("invalid id")
+	slugCleaner  = regexp.MustCompile(`[^a-z0-9]+`)
+	ulidPattern  = regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`)
+)
+
+func (mi *ModelItem[model]) GetIdStrategy() string {
+	if mi.IdStrategy == "" {
+		return IdObjectID
+	}
+	return mi.IdStrategy
+}
+
+func (mi *ModelItem[model]) idFieldType() reflect.Type {
+	switch mi.GetIdStrategy() {
+	case IdObjectID:
+		return reflect.TypeOf(primitive.ObjectID{})
+	case IdSequence:
+		return reflect.TypeOf(int64(0))
+	}
+	return reflect.TypeOf("")
+}
+
+func idFormat(strategy string) string {
+	switch strategy {
+	case IdUUIDv4, IdUUIDv7:
+		return "uuid"
+	case IdULID:
+		return "ulid"
+	case IdSequence:
+		return "int64"
+	case IdSlug:
+		return "slug"
+	case IdNatural:
+		return ""
+	}
+	return "objectid"
+}
+
+func (mi *ModelItem[model]) parseId(value string) (interface{}, error) {
+	switch mi.GetIdStrategy() {
+	case IdObjectID:
+		oid, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidId, err.Error())
+		}
+		return oid, nil
+	case IdUUIDv4, IdUUIDv7:
+		uid, err := uuid.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidId, err.Error())
+		}
+		return uid.String(), nil
+	case IdULID:
+		value = strings.ToUpper(value)
+		if !ulidPattern.MatchString(value) {
+			return nil, fmt.Errorf("%w: malformed ulid", ErrInvalidId)
+		}
+		return value, nil
+	case IdSequence:
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 1 {
+			return nil, fmt.Errorf("%w: sequence must be a positive integer", ErrInvalidId)
+		}
+		return seq, nil
+	}
+	if value == "" {
+		return nil, ErrInvalidId
+	}
+	return value, nil
+}
+
+func (mi *ModelItem[model]) newId(ctx context.Context, adata M, requested interface{}) (interface{}, error) {
+	switch mi.GetIdStrategy() {
+	case IdObjectID:
+		return primitive.NewObjectID(), nil
+	case IdUUIDv4:
+		return uuid.NewString(), nil
+	case IdUUIDv7:
+		return newUUIDv7()
+	case IdULID:
+		return newULID()
+	case IdSequence:
+		return mi.nextSequence(ctx)
+	case IdSlug:
+		return mi.newSlug(ctx, adata)
+	case IdNatural:
+		if requested == nil || requested == "" {
+			return nil, fmt.Errorf("%w: id is required", ErrInvalidId)
+		}
+		return mi.parseId(fmt.Sprint(requested))
+	}
+	return nil, fmt.Errorf("unknown id strategy %s", mi.IdStrategy)
+}
+
+func (mi *ModelItem[model]) nextSequence(ctx context.Context) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	raw, err := mi.store.Collection(counterCollection).Update(ctx,
+		M{"_id": mi.collectionName()},
+		M{"$inc": M{"seq": int64(1)}},
+		UpdateOptions{Upsert: true, ReturnAfter: true},
+	)
+	if err != nil {
+		return 0, err
+	}
+	err = bson.Unmarshal(raw, &counter)
+	return counter.Seq, err
+}
+
+func Slugify(value string) string {
+	return strings.Trim(slugCleaner.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
+
+func (mi *ModelItem[model]) newSlug(ctx context.Context, adata M) (string, error) {
+	if mi.SlugField == "" || adata[mi.SlugField] == nil {
+		return "", fmt.Errorf("%w: slug field %s is required", ErrInvalidId, mi.SlugField)
+	}
+	base := Slugify(fmt.Sprint(adata[mi.SlugField]))
+	if base == "" {
+		return "", fmt.Errorf("%w: slug field %s is empty", ErrInvalidId, mi.SlugField)
+	}
+	slug := base
+	for i := 2; ; i++ {
+		_, err := mi.colDb.FindOne(ctx, M{"_id": slug})
+		if err == ErrNoDocuments {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func newUUIDv7() (string, error) {
+	var uid uuid.UUID
+	_, err := rand.Read(uid[:])
+	if err != nil {
+		return "", err
+	}
+	ms := uint64(time.Now().UnixMilli())
+	uid[0] = byte(ms >> 40)
+	uid[1] = byte(ms >> 32)
+	uid[2] = byte(ms >> 24)
+	uid[3] = byte(ms >> 16)
+	uid[4] = byte(ms >> 8)
+	uid[5] = byte(ms)
+	uid[6] = (uid[6] & 0x0f) | 0x70
+	uid[8] = (uid[8] & 0x3f) | 0x80
+	return uid.String(), nil
+}
+
+func newULID() (string, error) {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], uint64(time.Now().UnixMilli())<<16)
+	_, err := rand.Read(data[6:])
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(data[:8])
+	lo := binary.BigEndian.Uint64(data[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+func idString(id interface{}) string {
+	switch item := id.(type) {
+	case primitive.ObjectID:
+		return item.Hex()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(id)
+}
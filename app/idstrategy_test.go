@@ -0,0 +1,102 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Order struct {
+	Total int64
+}
+
+type Shipment struct {
+	Carrier string
+}
+
+func TestSequenceCounterPerCollection(t *testing.T) {
+	h := apptest.New(t)
+	orders := app.NewModel[Order]("")
+	orders.IdStrategy = app.IdSequence
+	shipments := app.NewModel[Shipment]("")
+	shipments.IdStrategy = app.IdSequence
+	h.Register(orders, shipments)
+	client := h.As(app.M{})
+
+	var created struct {
+		Id int64 `json:"id"`
+	}
+	client.Post("/api/order/", app.M{"total": 5}).AssertStatus(201).Result(&created)
+	client.Post("/api/shipment/", app.M{"carrier": "post"}).AssertStatus(201).Result(&created)
+	if created.Id != 1 {
+		t.Fatalf("expected shipment to start its own sequence, got id %d", created.Id)
+	}
+
+	counters := map[string]interface{}{}
+	for _, doc := range h.Store.Documents("fimapi_counters") {
+		counters[doc["_id"].(string)] = doc["seq"]
+	}
+	if len(counters) != 2 || counters["order"] == nil || counters["shipment"] == nil {
+		t.Fatalf("expected counters keyed on collection names, got %v", counters)
+	}
+}
+
+type Page struct {
+	Title string
+}
+
+type racingSlugStore struct {
+	*apptest.MemoryStore
+}
+
+func (rs racingSlugStore) Collection(name string) app.StoreCollection {
+	col := rs.MemoryStore.Collection(name)
+	if name != "page" {
+		return col
+	}
+	return racingSlugCollection{col}
+}
+
+type racingSlugCollection struct {
+	app.StoreCollection
+}
+
+func (rc racingSlugCollection) Insert(ctx context.Context, doc app.M) (interface{}, error) {
+	if doc["_id"] == "hello-world" {
+		if _, err := rc.StoreCollection.FindOne(ctx, app.M{"_id": "hello-world"}); err == app.ErrNoDocuments {
+			rc.StoreCollection.Insert(ctx, app.M{"_id": "hello-world", "title": "Hello World"})
+		}
+	}
+	return rc.StoreCollection.Insert(ctx, doc)
+}
+
+func TestSlugRetriesAfterConcurrentInsert(t *testing.T) {
+	store := racingSlugStore{apptest.NewMemoryStore()}
+	dapp := app.NewWithStore(store, t.TempDir())
+	pages := app.NewModel[Page]("page")
+	pages.IdStrategy = app.IdSlug
+	pages.SlugField = "title"
+	dapp.RegisterModel(pages)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/page/", strings.NewReader(`{"title":"Hello World"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := dapp.Build().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		Result struct {
+			Id string `json:"id"`
+		} `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	if resp.StatusCode != fiber.StatusCreated || created.Result.Id != "hello-world-2" {
+		t.Fatalf("expected the next slug after a concurrent insert, got %d %+v", resp.StatusCode, created)
+	}
+}
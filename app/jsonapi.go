@@ -0,0 +1,428 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	jsonAPIMediaType   = "application/vnd.api+json"
+	jsonAPIResourceKey = "jsonapiResource"
+	jsonAPIPageKey     = "jsonapiPage"
+)
+
+type jsonAPIModel interface {
+	jsonAPIType() string
+	jsonAPIRelations() map[string]string
+	findByIds(c *fiber.Ctx, ids []string) ([]any, error)
+}
+
+type jsonAPIPage struct {
+	Offset int64
+	Limit  int64
+}
+
+type JSONAPIEnvelope struct {
+	app *App
+}
+
+func (mi *ModelItem[model]) jsonAPIType() string {
+	return mi.collectionName()
+}
+
+func (mi *ModelItem[model]) jsonAPIRelations() map[string]string {
+	relations := map[string]string{}
+	pnm := mi.model.(reflect.Type)
+	for i := 0; i < pnm.NumField(); i++ {
+		field := pnm.Field(i)
+		if ref := field.Tag.Get("ref"); ref != "" {
+			relations[strings.Split(field.Tag.Get("json"), ",")[0]] = ref
+		}
+	}
+	return relations
+}
+
+func (mi *ModelItem[model]) findByIds(c *fiber.Ctx, ids []string) ([]any, error) {
+	query, err := mi.scopeQuery(c)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, id := range ids {
+		value, err := mi.parseId(id)
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	query["_id"] = M{"$in": values}
+	if mi.SoftDelete {
+		query["is_deleted"] = false
+	}
+	cursor, err := mi.findCursor(c.Context(), query, nil, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := reflect.New(reflect.SliceOf(mi.model.(reflect.Type)))
+	err = cursor.All(c.Context(), items.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var out []any
+	for i := 0; i < items.Elem().Len(); i++ {
+		mi.applyComputed(items.Elem().Index(i))
+		out = append(out, items.Elem().Index(i).Interface())
+	}
+	return out, nil
+}
+
+func (app *App) jsonAPIEnvelope() *JSONAPIEnvelope {
+	return &JSONAPIEnvelope{app: app}
+}
+
+func acceptsJSONAPI(c *fiber.Ctx) bool {
+	return strings.Contains(c.Get(fiber.HeaderAccept), jsonAPIMediaType)
+}
+
+func unwrapJSONAPIBody(c *fiber.Ctx) error {
+	var body struct {
+		Data *struct {
+			Id            string                     `json:"id"`
+			Type          string                     `json:"type"`
+			Attributes    M                          `json:"attributes"`
+			Relationships map[string]json.RawMessage `json:"relationships"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(c.Body(), &body)
+	if err != nil {
+		return err
+	}
+	if body.Data == nil {
+		return fmt.Errorf("document must contain a data member")
+	}
+	flat := body.Data.Attributes
+	if flat == nil {
+		flat = M{}
+	}
+	if body.Data.Id != "" {
+		flat["id"] = body.Data.Id
+	}
+	for name, raw := range body.Data.Relationships {
+		var relation struct {
+			Data json.RawMessage `json:"data"`
+		}
+		err = json.Unmarshal(raw, &relation)
+		if err != nil {
+			return err
+		}
+		var many []struct {
+			Id string `json:"id"`
+		}
+		var one *struct {
+			Id string `json:"id"`
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(relation.Data), []byte("[")) {
+			err = json.Unmarshal(relation.Data, &many)
+			ids := []string{}
+			for _, item := range many {
+				ids = append(ids, item.Id)
+			}
+			flat[name] = ids
+		} else {
+			err = json.Unmarshal(relation.Data, &one)
+			if one != nil {
+				flat[name] = one.Id
+			} else {
+				flat[name] = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	bb, err := json.Marshal(flat)
+	if err != nil {
+		return err
+	}
+	c.Request().SetBody(bb)
+	c.Request().Header.SetContentType(fiber.MIMEApplicationJSON)
+	return nil
+}
+
+func jsonAPIFields(c *fiber.Ctx) map[string]map[string]bool {
+	fields := map[string]map[string]bool{}
+	c.Context().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		name := string(key)
+		if !strings.HasPrefix(name, "fields[") || !strings.HasSuffix(name, "]") {
+			return
+		}
+		set := map[string]bool{}
+		for _, item := range strings.Split(string(value), ",") {
+			if item != "" {
+				set[item] = true
+			}
+		}
+		fields[name[len("fields["):len(name)-1]] = set
+	})
+	return fields
+}
+
+func jsonAPIValue(value interface{}) string {
+	switch item := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return item
+	}
+	return fmt.Sprint(value)
+}
+
+func (je *JSONAPIEnvelope) relationType(name string) string {
+	if model, ok := je.app.findModel(name).(jsonAPIModel); ok {
+		return model.jsonAPIType()
+	}
+	return name
+}
+
+func (je *JSONAPIEnvelope) resource(model jsonAPIModel, item any, fields map[string]map[string]bool, refs map[string]map[string]bool) (M, error) {
+	bb, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.UseNumber()
+	var attributes M
+	err = decoder.Decode(&attributes)
+	if err != nil {
+		return nil, err
+	}
+	resourceType := model.jsonAPIType()
+	resource := M{
+		"type": resourceType,
+		"id":   jsonAPIValue(attributes["id"]),
+	}
+	delete(attributes, "id")
+	sparse, hasSparse := fields[resourceType]
+	relationships := M{}
+	for name, target := range model.jsonAPIRelations() {
+		value, ok := attributes[name]
+		delete(attributes, name)
+		if !ok || (hasSparse && !sparse[name]) {
+			continue
+		}
+		targetType := je.relationType(target)
+		linkage := func(id string) M {
+			if refs != nil && refs[name] != nil {
+				refs[name][id] = true
+			}
+			return M{"type": targetType, "id": id}
+		}
+		if list, ok := value.([]interface{}); ok {
+			data := []M{}
+			for _, id := range list {
+				data = append(data, linkage(jsonAPIValue(id)))
+			}
+			relationships[name] = M{"data": data}
+		} else if value == nil || jsonAPIValue(value) == "" {
+			relationships[name] = M{"data": nil}
+		} else {
+			relationships[name] = M{"data": linkage(jsonAPIValue(value))}
+		}
+	}
+	if hasSparse {
+		for name := range attributes {
+			if !sparse[name] {
+				delete(attributes, name)
+			}
+		}
+	}
+	resource["attributes"] = attributes
+	if len(relationships) > 0 {
+		resource["relationships"] = relationships
+	}
+	return resource, nil
+}
+
+func jsonAPIPageLink(c *fiber.Ctx, offset int64, limit int64) string {
+	query, _ := url.ParseQuery(string(c.Context().QueryArgs().QueryString()))
+	query.Del("offset")
+	query.Del("limit")
+	query.Set("page[offset]", strconv.FormatInt(offset, 10))
+	query.Set("page[limit]", strconv.FormatInt(limit, 10))
+	return fmt.Sprintf("%s%s?%s", c.BaseURL(), c.Path(), query.Encode())
+}
+
+func (je *JSONAPIEnvelope) Success(c *fiber.Ctx, code int, message string, data any) error {
+	body := M{
+		"jsonapi": M{"version": "1.1"},
+		"links":   M{"self": c.BaseURL() + c.OriginalURL()},
+	}
+	model, ok := c.Locals(jsonAPIResourceKey).(jsonAPIModel)
+	if !ok || data == nil {
+		meta := M{}
+		if message != "" {
+			meta["message"] = message
+		}
+		if data != nil {
+			meta["result"] = data
+		}
+		body["meta"] = meta
+		return je.write(c, code, body)
+	}
+	fields := jsonAPIFields(c)
+	relations := model.jsonAPIRelations()
+	refs := map[string]map[string]bool{}
+	for _, name := range strings.Split(c.Query("include"), ",") {
+		if name == "" {
+			continue
+		}
+		if _, ok := relations[name]; !ok {
+			return je.Failure(c, 400, fmt.Sprintf("relationship %s can not be included", name), nil)
+		}
+		refs[name] = map[string]bool{}
+	}
+	seen := map[string]bool{}
+	if list, ok := data.(M); ok && list["items"] != nil {
+		items := reflect.ValueOf(list["items"])
+		resources := []M{}
+		for i := 0; i < items.Len(); i++ {
+			resource, err := je.resource(model, items.Index(i).Interface(), fields, refs)
+			if err != nil {
+				return je.Failure(c, 500, "server error", err.Error())
+			}
+			seen[resource["type"].(string)+"/"+resource["id"].(string)] = true
+			resources = append(resources, resource)
+		}
+		body["data"] = resources
+		if page, ok := c.Locals(jsonAPIPageKey).(*jsonAPIPage); ok {
+			links := body["links"].(M)
+			links["first"] = jsonAPIPageLink(c, 0, page.Limit)
+			if page.Offset > 0 {
+				prev := page.Offset - page.Limit
+				if prev < 0 {
+					prev = 0
+				}
+				links["prev"] = jsonAPIPageLink(c, prev, page.Limit)
+			}
+			if int64(len(resources)) >= page.Limit {
+				links["next"] = jsonAPIPageLink(c, page.Offset+page.Limit, page.Limit)
+			}
+			body["meta"] = M{"offset": page.Offset, "limit": page.Limit, "count": len(resources)}
+		}
+	} else {
+		resource, err := je.resource(model, data, fields, refs)
+		if err != nil {
+			return je.Failure(c, 500, "server error", err.Error())
+		}
+		seen[resource["type"].(string)+"/"+resource["id"].(string)] = true
+		body["data"] = resource
+		if message != "" {
+			body["meta"] = M{"message": message}
+		}
+	}
+	if len(refs) > 0 {
+		included := []M{}
+		for name, ids := range refs {
+			target, ok := je.app.findModel(relations[name]).(jsonAPIModel)
+			if !ok || len(ids) == 0 {
+				continue
+			}
+			idList := make([]string, 0, len(ids))
+			for id := range ids {
+				idList = append(idList, id)
+			}
+			items, err := target.findByIds(c, idList)
+			if err != nil {
+				return je.Failure(c, 500, "server error", err.Error())
+			}
+			for _, item := range items {
+				resource, err := je.resource(target, item, fields, nil)
+				if err != nil {
+					return je.Failure(c, 500, "server error", err.Error())
+				}
+				key := resource["type"].(string) + "/" + resource["id"].(string)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				included = append(included, resource)
+			}
+		}
+		body["included"] = included
+	}
+	return je.write(c, code, body)
+}
+
+func (je *JSONAPIEnvelope) Failure(c *fiber.Ctx, code int, message string, data any) error {
+	item := M{
+		"status": strconv.Itoa(code),
+		"title":  http.StatusText(code),
+		"detail": message,
+	}
+	meta := M{}
+	if reqId, ok := c.UserContext().Value("request_id").(string); ok {
+		meta["request_id"] = reqId
+	}
+	if data != nil {
+		meta["error"] = data
+	}
+	if len(meta) > 0 {
+		item["meta"] = meta
+	}
+	return je.write(c, code, M{
+		"jsonapi": M{"version": "1.1"},
+		"errors":  []M{item},
+	})
+}
+
+func (je *JSONAPIEnvelope) write(c *fiber.Ctx, code int, body M) error {
+	err := c.Status(code).JSON(body)
+	c.Set(fiber.HeaderContentType, jsonAPIMediaType)
+	return err
+}
+
+func (je *JSONAPIEnvelope) SuccessSchema(result M) M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"jsonapi":  M{"type": "object"},
+			"links":    M{"type": "object", "additionalProperties": M{"type": "string"}},
+			"meta":     M{"type": "object"},
+			"data":     result,
+			"included": M{"type": "array", "items": M{"type": "object"}},
+		},
+	}
+}
+
+func (je *JSONAPIEnvelope) ErrorSchema() M {
+	return M{
+		"type": "object",
+		"properties": M{
+			"jsonapi": M{"type": "object"},
+			"errors": M{
+				"type": "array",
+				"items": M{
+					"type": "object",
+					"properties": M{
+						"status": M{"type": "string"},
+						"title":  M{"type": "string"},
+						"detail": M{"type": "string"},
+						"meta":   M{"type": "object"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (je *JSONAPIEnvelope) ErrorContentType() string {
+	return jsonAPIMediaType
+}
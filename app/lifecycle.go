@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type shutdownHook struct {
+	name string
+	fnc  func(ctx context.Context) error
+}
+
+func (app *App) OnShutdown(name string, fnc func(ctx context.Context) error) {
+	app.shutdownHooks = append(app.shutdownHooks, &shutdownHook{name: name, fnc: fnc})
+}
+
+func (app *App) drainTimeout() time.Duration {
+	if app.DrainTimeout > 0 {
+		return app.DrainTimeout
+	}
+	return time.Second * 15
+}
+
+func (app *App) background(fnc func()) {
+	app.pending.Add(1)
+	go func() {
+		defer app.pending.Done()
+		fnc()
+	}()
+}
+
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (app *App) Start(ctx context.Context, host string) error {
+	fapp, err := app.build()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen(fapp.Config().Network, host)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fapp.Listener(ln)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.drainTimeout())
+	defer cancel()
+	err = app.Shutdown(shutdownCtx)
+	ln.Close()
+	if listenErr := <-errs; err == nil {
+		err = listenErr
+	}
+	return err
+}
+
+func (app *App) RunWithSignals(host string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return app.Start(ctx, host)
+}
+
+func (app *App) ShuttingDown() bool {
+	return app.shuttingDown.Load()
+}
+
+func (app *App) Shutdown(ctx context.Context) error {
+	if !app.shuttingDown.CompareAndSwap(false, true) {
+		return nil
+	}
+	var errs []error
+	if app.DrainDelay > 0 {
+		select {
+		case <-time.After(app.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	if app.stopStreams != nil {
+		app.stopStreams()
+	}
+	if app.fiberApp != nil {
+		err := app.fiberApp.ShutdownWithContext(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("http server: %w", err))
+		}
+	}
+	if app.stopWorkers != nil {
+		app.stopWorkers()
+	}
+	err := waitGroup(ctx, &app.pending)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("background writes: %w", err))
+	}
+	for i := len(app.shutdownHooks) - 1; i >= 0; i-- {
+		hook := app.shutdownHooks[i]
+		err = hook.fnc(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hook.name, err))
+		}
+	}
+	if app.mongoClient != nil {
+		err = app.mongoClient.Disconnect(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mongo: %w", err))
+		}
+	}
+	if app.errorLogger != nil {
+		app.errorLogger.Sync()
+	}
+	return errors.Join(errs...)
+}
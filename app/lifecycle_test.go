@@ -0,0 +1,187 @@
+package app_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Feed struct {
+	Title string
+}
+
+func TestStartReturnsStartupErrors(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	api.RegisterMigration(1, "noop", func(ctx context.Context, db *mongo.Database) error {
+		return nil
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := api.Start(ctx, "127.0.0.1:0"); err == nil {
+		t.Fatal("expected start to return the startup error")
+	}
+}
+
+func TestStartReturnsInitErrors(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	api.Webhooks = true
+	err := api.Start(context.Background(), "127.0.0.1:0")
+	if err == nil || !strings.Contains(err.Error(), "SetWebhookAuthorizer") {
+		t.Fatalf("expected the missing authorizer error, got %v", err)
+	}
+}
+
+func TestStartReturnsBindErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	if err := api.Start(context.Background(), ln.Addr().String()); err == nil {
+		t.Fatal("expected start to fail on a taken address")
+	}
+}
+
+func TestStartStopsWhenCancelledEarly(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- api.Start(ctx, "127.0.0.1:0")
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("expected a clean stop, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("start did not return after the context was cancelled")
+	}
+}
+
+func startApp(t *testing.T, api *app.App) (string, context.CancelFunc, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- api.Start(ctx, addr)
+	}()
+	for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond * 20) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+	return "http://" + addr, cancel, stopped
+}
+
+func TestShutdownClosesWatchStreams(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	api.DrainTimeout = time.Second * 10
+	feeds := app.NewModel[Feed]("feed")
+	feeds.Watchable = true
+	api.RegisterModel(feeds)
+	addr, cancel, stopped := startApp(t, api)
+
+	base := addr + "/api/feed/"
+	streams := make(chan *http.Response, 1)
+	go func() {
+		stream, err := http.Get(base + "_watch")
+		if err == nil {
+			streams <- stream
+		}
+	}()
+	var stream *http.Response
+	for stream == nil {
+		select {
+		case stream = <-streams:
+		case <-time.After(time.Millisecond * 50):
+			resp, err := http.Post(base, "application/json", strings.NewReader(`{"title":"news"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	}
+	defer stream.Body.Close()
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("shutdown waited on the open watch stream")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*3 {
+		t.Fatalf("shutdown took %s with a watch subscriber connected", elapsed)
+	}
+}
+
+func TestDrainDelayKeepsServingWhileNotReady(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	api.DrainDelay = time.Millisecond * 500
+	api.RegisterModel(app.NewModel[Feed]("feed"))
+	addr, cancel, stopped := startApp(t, api)
+
+	cancel()
+	for !api.ShuttingDown() {
+		time.Sleep(time.Millisecond * 5)
+	}
+	resp, err := http.Get(addr + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected readiness to fail while draining, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(addr + "/api/feed/")
+	if err != nil {
+		t.Fatalf("expected requests to be served during the drain delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected list to succeed during the drain delay, got %d", resp.StatusCode)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadinessReportsIndexes(t *testing.T) {
+	h := apptest.New(t)
+	h.App.Idempotency = true
+
+	report := h.App.Readiness(context.Background())
+	if report.Status != app.HealthFail || report.Checks["indexes"] == nil || report.Checks["indexes"].Status != app.HealthFail {
+		t.Fatalf("expected indexes to be unready before build, got %+v", report.Checks["indexes"])
+	}
+	var ready app.HealthReport
+	h.Get("/readyz").AssertStatus(200).JSON(&ready)
+	if ready.Checks["indexes"] == nil || ready.Checks["indexes"].Status != app.HealthOk {
+		t.Fatalf("expected indexes to be ready after build, got %+v", ready.Checks)
+	}
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	migrationCollection     = "fimapi_migrations"
+	migrationLockCollection = "fimapi_migrations_lock"
+)
+
+const migrationLockLease = time.Minute
+
+var (
+	ErrMigrationLocked   = errors.New("migrations are locked by another instance")
+	ErrMigrationLockLost = errors.New("migration lock lost")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+type MigrationState struct {
+	Version   int64              `json:"version" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Applied   bool               `json:"applied" bson:"-"`
+	AppliedAt primitive.DateTime `json:"applied_at,omitempty" bson:"applied_at"`
+	Duration  int64              `json:"duration,omitempty" bson:"duration"`
+}
+
+func (app *App) RegisterMigration(version int64, name string, up func(ctx context.Context, db *mongo.Database) error, down func(ctx context.Context, db *mongo.Database) error) {
+	for _, item := range app.migrations {
+		if item.Version == version {
+			panic(fmt.Sprintf("migration %d already registered", version))
+		}
+	}
+	app.migrations = append(app.migrations, &Migration{
+		Version: version,
+		Name:    name,
+		Up:      up,
+		Down:    down,
+	})
+	sort.Slice(app.migrations, func(i, j int) bool {
+		return app.migrations[i].Version < app.migrations[j].Version
+	})
+}
+
+func (app *App) appliedMigrations(ctx context.Context) (map[int64]MigrationState, error) {
+	cursor, err := app.dbCon.Collection(migrationCollection).Find(ctx, M{})
+	if err != nil {
+		return nil, err
+	}
+	var states []MigrationState
+	err = cursor.All(ctx, &states)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]MigrationState{}
+	for _, state := range states {
+		state.Applied = true
+		applied[state.Version] = state
+	}
+	return applied, nil
+}
+
+func (app *App) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	applied, err := app.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var states []MigrationState
+	for _, item := range app.migrations {
+		state, ok := applied[item.Version]
+		if !ok {
+			state = MigrationState{Version: item.Version, Name: item.Name}
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (app *App) PendingMigrations(ctx context.Context) ([]*Migration, error) {
+	applied, err := app.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	for _, item := range app.migrations {
+		if _, ok := applied[item.Version]; !ok {
+			pending = append(pending, item)
+		}
+	}
+	return pending, nil
+}
+
+func (app *App) lockMigrations(ctx context.Context, timeout time.Duration) (context.Context, func(), error) {
+	col := app.dbCon.Collection(migrationLockCollection)
+	owner := uuid.NewString()
+	hostname, _ := os.Hostname()
+	deadline := time.Now().Add(timeout)
+	for {
+		now := time.Now()
+		_, err := col.UpdateOne(ctx,
+			M{"_id": "lock", "expires": M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			M{"$set": M{
+				"owner":   owner,
+				"host":    hostname,
+				"expires": primitive.NewDateTimeFromTime(now.Add(migrationLockLease)),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			lockCtx, cancel := context.WithCancelCause(ctx)
+			done := make(chan struct{})
+			go app.renewMigrationLock(lockCtx, cancel, owner, done)
+			return lockCtx, func() {
+				cancel(nil)
+				<-done
+				col.DeleteOne(context.Background(), M{"_id": "lock", "owner": owner})
+			}, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (app *App) renewMigrationLock(ctx context.Context, cancel context.CancelCauseFunc, owner string, done chan struct{}) {
+	defer close(done)
+	col := app.dbCon.Collection(migrationLockCollection)
+	ticker := time.NewTicker(migrationLockLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := col.UpdateOne(ctx,
+				M{"_id": "lock", "owner": owner},
+				M{"$set": M{"expires": primitive.NewDateTimeFromTime(time.Now().Add(migrationLockLease))}},
+			)
+			if err == nil && result.MatchedCount == 0 {
+				err = ErrMigrationLockLost
+			}
+			if err != nil && ctx.Err() == nil {
+				app.logger.Error("migration lock renewal", zap.Error(err))
+				cancel(ErrMigrationLockLost)
+				return
+			}
+		}
+	}
+}
+
+func (app *App) Migrate(ctx context.Context) error {
+	if len(app.migrations) == 0 {
+		return nil
+	}
+	ctx, unlock, err := app.lockMigrations(ctx, time.Minute*5)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	pending, err := app.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	col := app.dbCon.Collection(migrationCollection)
+	for _, item := range pending {
+		start := time.Now()
+		app.logger.Info("migration", zap.Int64("version", item.Version), zap.String("name", item.Name))
+		err = item.Up(ctx, app.dbCon)
+		if cause := context.Cause(ctx); errors.Is(cause, ErrMigrationLockLost) {
+			err = cause
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", item.Version, item.Name, err)
+		}
+		_, err = col.InsertOne(ctx, MigrationState{
+			Version:   item.Version,
+			Name:      item.Name,
+			AppliedAt: primitive.NewDateTimeFromTime(start),
+			Duration:  time.Since(start).Milliseconds(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) MigrateDown(ctx context.Context, target int64) error {
+	ctx, unlock, err := app.lockMigrations(ctx, time.Minute*5)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	applied, err := app.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	col := app.dbCon.Collection(migrationCollection)
+	for i := len(app.migrations) - 1; i >= 0; i-- {
+		item := app.migrations[i]
+		if item.Version <= target {
+			break
+		}
+		if _, ok := applied[item.Version]; !ok {
+			continue
+		}
+		if item.Down == nil {
+			return fmt.Errorf("migration %d %s has no down function", item.Version, item.Name)
+		}
+		app.logger.Info("migration down", zap.Int64("version", item.Version), zap.String("name", item.Name))
+		err = item.Down(ctx, app.dbCon)
+		if cause := context.Cause(ctx); errors.Is(cause, ErrMigrationLockLost) {
+			err = cause
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", item.Version, item.Name, err)
+		}
+		_, err = col.DeleteOne(ctx, M{"_id": item.Version})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
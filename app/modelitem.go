@@ -2,18 +2,21 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/stoewer/go-strcase"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
 )
 
 type ModelItem[model any] struct {
@@ -35,8 +38,25 @@ type ModelItem[model any] struct {
 	NoUpdate               bool
 	LimitNoChange          bool
 	SoftDelete             bool
+	Versioned              bool
+	TenantField            string
+	NaturalKeys            []string
+	IdStrategy             string
+	SlugField              string
+	computed               []*computedField
+	Watchable              bool
+	hub                    *watchHub
+	baseCtx                context.Context
+	changeListeners        []func(*ChangeEvent)
+	bus                    EventBus
+	logger                 *zap.Logger
+	watchWebSocket         func(*fiber.Ctx) error
+	tenantCollections      map[string]string
 	NoGet                  bool
 	responseLimit          int64
+	CacheTTL               time.Duration
+	RateLimit              *RateLimit
+	cache                  Cache
 	NoList                 bool
 	collection             string
 	Title                  string
@@ -45,13 +65,17 @@ type ModelItem[model any] struct {
 	modelType              reflect.Type
 	UpdateOnAddFunction    func(item M, c *fiber.Ctx) (M, error)
 	UpdateOnUpdateFunction func(item M, c *fiber.Ctx) (M, error)
+	AfterAddFunction       func(item M, c *fiber.Ctx) error
+	AfterUpdateFunction    func(item M, c *fiber.Ctx) error
+	AfterDeleteFunction    func(item M, c *fiber.Ctx) error
+	Transactional          bool
 	endpointsGet           []*EndPoint
 	endpointsPost          []*EndPoint
 	endpointsDelete        []*EndPoint
 	endpointsPut           []*EndPoint
 	name                   string
-	dbCon                  *mongo.Database
-	colDb                  *mongo.Collection
+	store                  Store
+	colDb                  StoreCollection
 }
 
 func (mi *ModelItem[model]) AddGetEndpoint(path string, requestParams interface{}, responseModel interface{}, function func(*fiber.Ctx)) {
@@ -70,12 +94,7 @@ func (mi *ModelItem[model]) R400(c *fiber.Ctx, message string, data any) error {
 	return mi.RError(c, 400, message, data)
 }
 func (mi *ModelItem[model]) RError(c *fiber.Ctx, code int, message string, data any) error {
-	return c.Status(code).JSON(Response{
-		Message:    message,
-		Status:     false,
-		StatusCode: code,
-		Error:      data,
-	})
+	return RError(c, code, message, data)
 }
 func (mi *ModelItem[model]) R500(c *fiber.Ctx, message string, data any) error {
 	return mi.RError(c, 500, message, data)
@@ -84,12 +103,7 @@ func (mi *ModelItem[model]) R404(c *fiber.Ctx, message string) error {
 	return mi.RError(c, 404, message, nil)
 }
 func (mi *ModelItem[model]) ROk(c *fiber.Ctx, code int, message string, data any) error {
-	return c.Status(code).JSON(Response{
-		Message:    message,
-		Status:     true,
-		StatusCode: code,
-		Result:     data,
-	})
+	return ROk(c, code, message, data)
 }
 func (mi *ModelItem[model]) R200(c *fiber.Ctx, message string, data any) error {
 	return mi.ROk(c, 200, message, data)
@@ -105,10 +119,9 @@ func (mi *ModelItem[model]) SetInfo(title string, desc string, tags []string) {
 }
 func (mi *ModelItem[model]) GetAggregate(c *fiber.Ctx, aggrage []M, requestItem interface{}, responseItem interface{}, method string) error {
 
-	localGet := c.Locals("authQuery")
-	var extraQuery M
-	if localGet != nil {
-		extraQuery = localGet.(M)
+	extraQuery, err := mi.scopeQuery(c)
+	if err != nil {
+		return mi.RError(c, 403, err.Error(), nil)
 	}
 	var aggrageBase []M
 
@@ -146,7 +159,11 @@ func (mi *ModelItem[model]) GetAggregate(c *fiber.Ctx, aggrage []M, requestItem
 	if err != nil {
 		panic(err)
 	}
-	if extraQuery != nil {
+	aggrageBase, err = mi.scopeLookups(aggrageBase, extraQuery)
+	if err != nil {
+		return mi.RError(c, 403, err.Error(), nil)
+	}
+	if len(extraQuery) > 0 {
 		aggrageBase = append([]M{M{"$match": extraQuery}}, aggrageBase...)
 	}
 	cursor, err := mi.colDb.Aggregate(c.Context(), aggrageBase)
@@ -154,9 +171,8 @@ func (mi *ModelItem[model]) GetAggregate(c *fiber.Ctx, aggrage []M, requestItem
 		panic(err)
 	}
 	respItemType := reflect.TypeOf(responseItem)
-	totalLength := cursor.RemainingBatchLength()
 	sliceElem := reflect.SliceOf(respItemType)
-	respItems := reflect.MakeSlice(sliceElem, totalLength, totalLength).Interface()
+	respItems := reflect.MakeSlice(sliceElem, 0, 0).Interface()
 	err = cursor.All(c.Context(), &respItems)
 	if err != nil {
 		panic(err)
@@ -168,7 +184,16 @@ func (mi *ModelItem[model]) UpdateOnAdd(fnc func(item M, c *fiber.Ctx) (M, error
 	mi.UpdateOnAddFunction = fnc
 }
 func (mi *ModelItem[model]) UpdateOnUpdate(fnc func(item M, c *fiber.Ctx) (M, error)) {
-	mi.UpdateOnAddFunction = fnc
+	mi.UpdateOnUpdateFunction = fnc
+}
+func (mi *ModelItem[model]) AfterAdd(fnc func(item M, c *fiber.Ctx) error) {
+	mi.AfterAddFunction = fnc
+}
+func (mi *ModelItem[model]) AfterUpdate(fnc func(item M, c *fiber.Ctx) error) {
+	mi.AfterUpdateFunction = fnc
+}
+func (mi *ModelItem[model]) AfterDelete(fnc func(item M, c *fiber.Ctx) error) {
+	mi.AfterDeleteFunction = fnc
 }
 func (mi *ModelItem[model]) AddAggrageEndPoint(path string, method string, responseModel interface{}, requestModel interface{}, aggrage []M) *EndPoint {
 
@@ -219,236 +244,149 @@ func (mi *ModelItem[model]) DeleteEndPoints() []*EndPoint {
 	return mi.endpointsDelete
 }
 func (mi *ModelItem[model]) SetDb(db *mongo.Database) {
-	mi.dbCon = db
+	mi.store = NewMongoStore(db)
+}
+func (mi *ModelItem[model]) SetStore(store Store) {
+	mi.store = store
+}
+func (mi *ModelItem[model]) SetCache(cache Cache) {
+	mi.cache = cache
+}
+func (mi *ModelItem[model]) invalidateCache() {
+	if mi.cache != nil {
+		mi.cache.Invalidate(mi.name)
+	}
 }
 func (mi *ModelItem[model]) GetItem(c *fiber.Ctx) error {
+	c.Locals(jsonAPIResourceKey, mi)
 	oid := c.Params("id", "")
-	if oid != "" {
-		var err error
-		objectId, err := primitive.ObjectIDFromHex(oid)
-		if err != nil {
-			return mi.R400(c, "objectId decode error", M{"error": err})
-		}
-		query := M{}
-		extraQuery := c.Locals("authQuery")
-		if extraQuery != nil {
-			query = extraQuery.(M)
-		}
-		query["_id"] = objectId
-		if mi.SoftDelete {
-			query["is_deleted"] = false
-
-		}
-		item := mi.colDb.FindOne(c.Context(), query)
-		if item.Err() != nil {
-			return mi.R404(c, "item not found")
-		}
-		pnm := mi.model.(reflect.Type)
-		respItem := reflect.New(pnm).Elem().Addr().Interface()
-		err = item.Decode(respItem)
-		if err != nil {
-			return mi.R500(c, "server error", err)
-		}
-		return mi.R200(c, "", respItem)
+	if oid == "" {
+		return mi.R400(c, "required item path", nil)
 	}
-	return mi.R400(c, "required item path", nil)
+	respItem, err := mi.findOne(c, oid)
+	if err != nil {
+		return mi.respondError(c, err)
+	}
+	return mi.R200(c, "", respItem)
 }
 
 func (mi *ModelItem[model]) GetItems(c *fiber.Ctx) error {
-
-	query := M{}
-	extraQuery := c.Locals("authQuery")
-	if extraQuery != nil {
-		query = extraQuery.(M)
-	}
-	if mi.SoftDelete {
-		query["is_deleted"] = false
-	}
-	opt := options.Find()
-	limit := int64(10)
-	if mi.responseLimit > 0 {
-		limit = mi.responseLimit
-	}
+	c.Locals(jsonAPIResourceKey, mi)
 	var params DefaultQuery
-	c.ParamsParser(&params)
-	if !mi.LimitNoChange && params.Limit != 0 {
-		limit = params.Limit
+	c.QueryParser(&params)
+	if value, err := strconv.ParseInt(c.Query("page[offset]"), 10, 64); err == nil {
+		params.Offset = value
 	}
-	offset := int64(0)
-	if params.Offset != 0 {
-		offset = params.Offset
+	if value, err := strconv.ParseInt(c.Query("page[limit]"), 10, 64); err == nil {
+		params.Limit = value
 	}
-	opt.SetSkip(offset)
-	opt.SetLimit(limit)
-
-	cursor, err := mi.colDb.Find(c.Context(), query)
+	filters := map[string]string{}
+	c.Context().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		filters[string(key)] = string(value)
+	})
+	c.Locals(jsonAPIPageKey, &jsonAPIPage{Offset: params.Offset, Limit: mi.listLimit(params.Limit)})
+	list := ListQuery{
+		Offset:  params.Offset,
+		Limit:   params.Limit,
+		Sort:    params.Sort,
+		Filters: filters,
+	}
+	respItems, err := mi.findMany(c, list)
 	if err != nil {
-		return mi.R500(c, "server error", err)
+		return mi.respondError(c, err)
 	}
-	pnm := mi.model.(reflect.Type)
-	totalLength := cursor.RemainingBatchLength()
-	sliceElem := reflect.SliceOf(pnm)
-	respItems := reflect.MakeSlice(sliceElem, totalLength, totalLength).Interface()
-
-	err = cursor.All(c.Context(), &respItems)
+	total, err := mi.countMany(c, list)
 	if err != nil {
-		fmt.Println(err.Error())
-		return mi.R500(c, "server error", err.Error())
+		return mi.respondError(c, err)
 	}
 
 	return mi.R200(c, "", M{
-		"total": totalLength,
-		"items": respItems,
-		"start": offset,
+		"total": total,
+		"items": respItems.Interface(),
+		"start": params.Offset,
 	})
 
 }
 func (mi *ModelItem[model]) UpdateItem(c *fiber.Ctx) error {
-	pnm := mi.model.(reflect.Type)
-	insertobj := reflect.New(pnm).Interface()
-
+	c.Locals(jsonAPIResourceKey, mi)
+	oid := c.Params("id", "")
+	if oid == "" {
+		return mi.R400(c, "required item path", nil)
+	}
+	insertobj := reflect.New(mi.model.(reflect.Type)).Interface()
 	err := c.BodyParser(&insertobj)
-
 	if err != nil {
 		return mi.R400(c, "body parse error", err.Error())
 	}
-	bb, _ := json.MarshalIndent(insertobj, "", "\t")
-	var adata M
-	json.Unmarshal(bb, &adata)
-	if mi.SoftDelete {
-		adata["is_deleted"] = false
-
-	}
-	delete(adata, "id")
-
-	if mi.UpdateOnAddFunction != nil {
-		adata, err = mi.UpdateOnAddFunction(adata, c)
-		if err != nil {
-			return mi.R500(c, "internal server error", err.Error())
-		}
-	}
-	insertId, err := mi.colDb.InsertOne(c.Context(), adata)
-	if err != nil {
-		return mi.R500(c, "internal server error", err.Error())
-	}
-	objId := insertId.InsertedID.(primitive.ObjectID)
-	itmCur := mi.colDb.FindOne(c.Context(), M{"_id": objId})
-	if itmCur.Err() != nil {
-		return mi.R500(c, "internal server error", itmCur.Err())
-	}
-	err = itmCur.Decode(insertobj)
+	respItem, err := mi.updateOne(c, oid, mi.structData(insertobj))
 	if err != nil {
-		return mi.R500(c, "internal server error", err.Error())
+		return mi.respondError(c, err)
 	}
-	return mi.R201(c, "item created", &insertobj)
+	return mi.R200(c, "item updated", respItem)
 }
 func (mi *ModelItem[model]) CreateItem(c *fiber.Ctx) error {
-	pnm := mi.model.(reflect.Type)
-	insertobj := reflect.New(pnm).Interface()
-
+	c.Locals(jsonAPIResourceKey, mi)
+	insertobj := reflect.New(mi.model.(reflect.Type)).Interface()
 	err := c.BodyParser(&insertobj)
-
 	if err != nil {
 		return mi.R400(c, "body parse error", err.Error())
 	}
-	bb, _ := json.MarshalIndent(insertobj, "", "\t")
-	var adata M
-	json.Unmarshal(bb, &adata)
-	if mi.SoftDelete {
-		adata["is_deleted"] = false
-
-	}
-	delete(adata, "id")
-	if mi.UpdateOnAddFunction != nil {
-		adata, err = mi.UpdateOnAddFunction(adata, c)
-		if err != nil {
-			return mi.R500(c, "internal server error", err.Error())
-		}
-	}
-	insertId, err := mi.colDb.InsertOne(c.Context(), adata)
+	respItem, err := mi.insertOne(c, mi.structData(insertobj))
 	if err != nil {
-		return mi.R500(c, "internal server error", err.Error())
+		return mi.respondError(c, err)
 	}
-	objId := insertId.InsertedID.(primitive.ObjectID)
-	itmCur := mi.colDb.FindOne(c.Context(), M{"_id": objId})
-	if itmCur.Err() != nil {
-		return mi.R500(c, "internal server error", itmCur.Err())
+	return mi.R201(c, "item created", respItem)
+}
+func (mi *ModelItem[model]) DeleteItem(c *fiber.Ctx) error {
+	c.Locals(jsonAPIResourceKey, mi)
+	oid := c.Params("id", "")
+	if oid == "" {
+		return mi.R400(c, "required delete path", nil)
 	}
-	err = itmCur.Decode(insertobj)
+	err := mi.deleteOne(c, oid)
 	if err != nil {
-		return mi.R500(c, "internal server error", err.Error())
+		return mi.respondError(c, err)
 	}
-	return mi.R201(c, "item created", &insertobj)
+	return mi.R200(c, "item deleted", nil)
 }
-func (mi *ModelItem[model]) DeleteItem(c *fiber.Ctx) error {
-	oid := c.Params("id", "")
-	if oid != "" {
-		var err error
-		objectId, err := primitive.ObjectIDFromHex(oid)
-		if err != nil {
-			return mi.R400(c, "objectId decode error", M{"error": err})
-		}
-		var actionCount int
-		if mi.SoftDelete {
-			var result *mongo.UpdateResult
-			result, err = mi.colDb.UpdateOne(c.Context(), M{"_id": objectId, "is_deleted": false}, M{"$set": M{"is_deleted": true}})
-			actionCount = int(result.ModifiedCount)
-		} else {
-			var result *mongo.DeleteResult
-			result, err = mi.colDb.DeleteOne(c.Context(), M{"_id": objectId})
-			actionCount = int(result.DeletedCount)
-		}
 
-		if err != nil {
-			return mi.R500(c, "server error", err)
-		}
-		if actionCount == 0 {
-			return mi.R400(c, "item already deleted or cant found", nil)
-		}
-		return mi.R400(c, "required delete path", nil)
+func (mi *ModelItem[model]) RestoreItem(c *fiber.Ctx) error {
+	c.Locals(jsonAPIResourceKey, mi)
+	oid := c.Params("id", "")
+	if oid == "" {
+		return mi.R400(c, "required restore path", nil)
+	}
+	respItem, err := mi.restoreOne(c, oid)
+	if err != nil {
+		return mi.respondError(c, err)
 	}
-	return mi.R400(c, "required delete path", nil)
+	return mi.R200(c, "item restored", respItem)
 }
 
 func (mi *ModelItem[model]) GetModelType() interface{} {
 	return mi.model
 }
 func (mi *ModelItem[model]) Tags() {
-	mi.modelType = reflect.TypeOf(mi.model).Elem()
-	lenField := mi.modelType.NumField()
-	f := []reflect.StructField{}
+	mi.modelType = reflect.TypeOf(mi.modelIt).Elem()
+	f := tagFields(mi.modelType, map[reflect.Type]bool{mi.modelType: true})
 	hasId := false
 	hasDeleted := false
-	for i := 0; i < lenField; i++ {
-		fld := mi.modelType.Field(i).Tag
-		hasJson := fld.Get("json")
-		hasBson := fld.Get("bson")
-		field := mi.modelType.Field(i)
-		name := field.Name
-
-		if hasBson == "" {
-			hasBson = fmt.Sprintf("%s,omitempty", strcase.SnakeCase(name))
-		}
-		if hasJson == "" {
-			hasJson = fmt.Sprintf("%s,omitempty", strcase.SnakeCase(name))
-		}
-		f = append(f, reflect.StructField{
-			Name: name,
-			Type: field.Type,
-			Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s" bson:"%s"`, hasJson, hasBson)),
-		})
-		if name == "Id" {
+	hasVersion := false
+	for _, field := range f {
+		switch field.Name {
+		case "Id":
 			hasId = true
-		}
-		if name == "IsDeleted" {
+		case "IsDeleted":
 			hasDeleted = true
+		case "Version":
+			hasVersion = true
 		}
 	}
 	if !hasId {
 		f = append(f, reflect.StructField{
 			Name: "Id",
-			Type: reflect.TypeOf(primitive.ObjectID{}),
-			Tag:  reflect.StructTag(`json:"id,omitempty" bson:"_id,omitempty"`),
+			Type: mi.idFieldType(),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:"id,omitempty" bson:"_id,omitempty" format:"%s"`, idFormat(mi.GetIdStrategy()))),
 		})
 	}
 
@@ -461,18 +399,43 @@ func (mi *ModelItem[model]) Tags() {
 			})
 		}
 	}
+	if mi.Versioned {
+		if !hasVersion {
+			f = append(f, reflect.StructField{
+				Name: "Version",
+				Type: reflect.TypeOf(int64(0)),
+				Tag:  reflect.StructTag(`json:"version,omitempty" bson:"version"`),
+			})
+		}
+	}
+	f = append(f, mi.computedStructFields()...)
 	mi.model = reflect.StructOf(f)
 }
 func (mi *ModelItem[model]) GetName() string {
 	return mi.name
 }
+func (mi *ModelItem[model]) collectionName() string {
+	return strcase.SnakeCase(mi.name)
+}
 func (mi *ModelItem[model]) Generate() {
+	mi.Tags()
 	mi.name = reflect.TypeOf(mi.modelIt).Elem().Name()
-	path := strcase.SnakeCase(mi.name)
-	mi.colDb = mi.dbCon.Collection(path)
+	path := mi.collectionName()
+	mi.colDb = mi.store.Collection(path)
+	for _, endpoints := range [][]*EndPoint{mi.endpointsGet, mi.endpointsPost} {
+		for _, end := range endpoints {
+			if end.IsAggregade {
+				if end.CacheTTL == 0 {
+					end.CacheTTL = mi.CacheTTL
+				}
+				end.CacheDepends = append([]string{mi.name}, end.CacheDepends...)
+			}
+		}
+	}
 	if !mi.NoDelete {
 		mi.endpointsDelete = append(mi.endpointsDelete, &EndPoint{
 			function:      mi.DeleteItem,
+			RPCMethod:     path + ".delete",
 			Name:          uuid.NewString(),
 			responseModel: Response{},
 			Single:        true,
@@ -480,19 +443,40 @@ func (mi *ModelItem[model]) Generate() {
 			docpath:       fmt.Sprintf("/api/%s/{id}", path),
 		})
 	}
+	if mi.Watchable {
+		mi.hub = newWatchHub(1000)
+		mi.watchWebSocket = websocket.New(mi.watchWebSocketHandler)
+		mi.endpointsGet = append(mi.endpointsGet, &EndPoint{
+			function:    mi.WatchSSE,
+			Name:        uuid.NewString(),
+			RPCMethod:   "-",
+			Description: fmt.Sprintf("Stream %s changes as server-sent events", mi.name),
+			path:        fmt.Sprintf("%s/_watch", path),
+			docpath:     fmt.Sprintf("/api/%s/_watch", path),
+		}, &EndPoint{
+			function:  mi.WatchWebSocketUpgrade,
+			Name:      uuid.NewString(),
+			RPCMethod: "-",
+			path:      fmt.Sprintf("%s/_ws", path),
+		})
+	}
 	if !mi.NoGet {
 		mi.endpointsGet = append(mi.endpointsGet, &EndPoint{
 			function:      mi.GetItem,
+			RPCMethod:     path + ".get",
 			Name:          uuid.NewString(),
 			Single:        true,
 			responseModel: Response{},
+			CacheTTL:      mi.CacheTTL,
+			CacheDepends:  []string{mi.name},
 			path:          fmt.Sprintf("%s/:id", path),
 			docpath:       fmt.Sprintf("/api/%s/{id}", path),
 		})
 	}
 	if !mi.NoUpdate {
 		mi.endpointsPut = append(mi.endpointsPut, &EndPoint{
-			function:      mi.GetItem,
+			function:      mi.UpdateItem,
+			RPCMethod:     path + ".update",
 			Name:          uuid.NewString(),
 			Single:        true,
 			responseModel: Response{},
@@ -503,16 +487,32 @@ func (mi *ModelItem[model]) Generate() {
 	if !mi.NoList {
 		mi.endpointsGet = append(mi.endpointsGet, &EndPoint{
 			function:      mi.GetItems,
+			RPCMethod:     path + ".list",
 			Name:          uuid.NewString(),
 			List:          true,
 			responseModel: Response{},
+			CacheTTL:      mi.CacheTTL,
+			CacheDepends:  []string{mi.name},
 			path:          fmt.Sprintf("%s/", path),
 			docpath:       fmt.Sprintf("/api/%s/", path),
 		})
 	}
+	if mi.SoftDelete && !mi.NoDelete {
+		mi.endpointsPost = append(mi.endpointsPost, &EndPoint{
+			function:      mi.RestoreItem,
+			RPCMethod:     path + ".restore",
+			Name:          uuid.NewString(),
+			Description:   fmt.Sprintf("Restore a deleted %s", mi.name),
+			responseModel: Response{},
+			Single:        true,
+			path:          fmt.Sprintf("%s/:id/restore", path),
+			docpath:       fmt.Sprintf("/api/%s/{id}/restore", path),
+		})
+	}
 	if !mi.NoInsert {
 		mi.endpointsPost = append(mi.endpointsPost, &EndPoint{
 			function:      mi.CreateItem,
+			RPCMethod:     path + ".create",
 			Name:          uuid.NewString(),
 			responseModel: Response{},
 			Single:        true,
@@ -520,6 +520,13 @@ func (mi *ModelItem[model]) Generate() {
 			docpath:       fmt.Sprintf("/api/%s/", path),
 		})
 	}
+	for _, endpoints := range [][]*EndPoint{mi.endpointsGet, mi.endpointsPost, mi.endpointsPut, mi.endpointsDelete} {
+		for _, end := range endpoints {
+			if end.RateLimit == nil {
+				end.RateLimit = mi.RateLimit
+			}
+		}
+	}
 }
 
 func NewModel[Model any](collection string) *ModelItem[Model] {
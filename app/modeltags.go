@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stoewer/go-strcase"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var taggedTypes sync.Map
+
+var (
+	jsonMarshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	bsonMarshalerType  = reflect.TypeOf((*bson.Marshaler)(nil)).Elem()
+	bsonValueMarshaler = reflect.TypeOf((*bson.ValueMarshaler)(nil)).Elem()
+)
+
+func isLeafStruct(t reflect.Type) bool {
+	if t == reflect.TypeOf(time.Time{}) || strings.HasPrefix(t.PkgPath(), "go.mongodb.org/mongo-driver") {
+		return true
+	}
+	for _, item := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if item.Implements(jsonMarshalerType) || item.Implements(bsonMarshalerType) || item.Implements(bsonValueMarshaler) {
+			return true
+		}
+	}
+	return false
+}
+
+func originalType(t reflect.Type) reflect.Type {
+	if item, ok := taggedTypes.Load(t); ok {
+		return item.(reflect.Type)
+	}
+	return t
+}
+
+func tagType(t reflect.Type, visiting map[reflect.Type]bool) reflect.Type {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		elem := tagType(t.Elem(), visiting)
+		if elem == t.Elem() {
+			return t
+		}
+		switch t.Kind() {
+		case reflect.Ptr:
+			return reflect.PtrTo(elem)
+		case reflect.Slice:
+			return reflect.SliceOf(elem)
+		case reflect.Array:
+			return reflect.ArrayOf(t.Len(), elem)
+		}
+		return reflect.MapOf(t.Key(), elem)
+	case reflect.Struct:
+		if isLeafStruct(t) || visiting[t] {
+			return t
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		tagged := reflect.StructOf(tagFields(t, visiting))
+		if t.Name() != "" {
+			taggedTypes.Store(tagged, t)
+		}
+		return tagged
+	}
+	return t
+}
+
+func tagFields(t reflect.Type, visiting map[reflect.Type]bool) []reflect.StructField {
+	own := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous || field.Tag.Get("json") != "" {
+			own[field.Name] = true
+		}
+	}
+	seen := map[string]bool{}
+	f := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if !field.IsExported() && !(field.Anonymous && fieldType.Kind() == reflect.Struct) {
+			continue
+		}
+		if field.Anonymous && field.Tag.Get("json") == "" && fieldType.Kind() == reflect.Struct && !isLeafStruct(fieldType) {
+			if visiting[fieldType] {
+				continue
+			}
+			visiting[fieldType] = true
+			for _, inner := range tagFields(fieldType, visiting) {
+				if own[inner.Name] || seen[inner.Name] {
+					continue
+				}
+				seen[inner.Name] = true
+				f = append(f, inner)
+			}
+			delete(visiting, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		hasJson := field.Tag.Get("json")
+		hasBson := field.Tag.Get("bson")
+		if hasBson == "" {
+			hasBson = fmt.Sprintf("%s,omitempty", strcase.SnakeCase(field.Name))
+		}
+		if hasJson == "" {
+			hasJson = fmt.Sprintf("%s,omitempty", strcase.SnakeCase(field.Name))
+		}
+		tag := fmt.Sprintf(`json:"%s" bson:"%s"`, hasJson, hasBson)
+		for _, key := range []string{"format", "ref"} {
+			if value := field.Tag.Get(key); value != "" {
+				tag = fmt.Sprintf(`%s %s:"%s"`, tag, key, value)
+			}
+		}
+		seen[field.Name] = true
+		f = append(f, reflect.StructField{
+			Name: field.Name,
+			Type: tagType(field.Type, visiting),
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+	return f
+}
+
+func copyValue(dst reflect.Value, src reflect.Value) {
+	if !src.IsValid() || !dst.CanSet() {
+		return
+	}
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src.Kind() != reflect.Ptr || src.IsNil() {
+			return
+		}
+		item := reflect.New(dst.Type().Elem())
+		copyValue(item.Elem(), src.Elem())
+		dst.Set(item)
+	case reflect.Slice:
+		if src.Kind() != reflect.Slice || src.IsNil() {
+			return
+		}
+		items := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			copyValue(items.Index(i), src.Index(i))
+		}
+		dst.Set(items)
+	case reflect.Array:
+		if src.Kind() != reflect.Array {
+			return
+		}
+		for i := 0; i < src.Len() && i < dst.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.Kind() != reflect.Map || src.IsNil() {
+			return
+		}
+		items := reflect.MakeMapWithSize(dst.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			item := reflect.New(dst.Type().Elem()).Elem()
+			copyValue(item, iter.Value())
+			items.SetMapIndex(iter.Key(), item)
+		}
+		dst.Set(items)
+	case reflect.Struct:
+		if src.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < dst.NumField(); i++ {
+			field := dst.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Anonymous && !src.FieldByName(field.Name).IsValid() {
+				target := dst.Field(i)
+				if target.Kind() == reflect.Ptr {
+					target.Set(reflect.New(target.Type().Elem()))
+					target = target.Elem()
+				}
+				copyValue(target, src)
+				continue
+			}
+			copyValue(dst.Field(i), src.FieldByName(field.Name))
+		}
+	}
+}
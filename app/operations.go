@@ -0,0 +1,366 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type OpError struct {
+	Code    int
+	Message string
+	Data    any
+}
+
+func (e *OpError) Error() string {
+	return e.Message
+}
+
+func opError(code int, message string, data any) error {
+	return &OpError{Code: code, Message: message, Data: data}
+}
+
+type ListQuery struct {
+	Offset  int64
+	Limit   int64
+	Sort    string
+	Filters map[string]string
+}
+
+func (mi *ModelItem[model]) respondError(c *fiber.Ctx, err error) error {
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return mi.RError(c, opErr.Code, opErr.Message, opErr.Data)
+	}
+	return mi.R500(c, "server error", err.Error())
+}
+
+func (mi *ModelItem[model]) normalizeInput(data M) (M, error) {
+	bb, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	insertobj := reflect.New(mi.model.(reflect.Type)).Interface()
+	err = json.Unmarshal(bb, insertobj)
+	if err != nil {
+		return nil, opError(400, "body parse error", err.Error())
+	}
+	return mi.structData(insertobj), nil
+}
+
+func (mi *ModelItem[model]) structData(item any) M {
+	bb, _ := json.Marshal(item)
+	var adata M
+	json.Unmarshal(bb, &adata)
+	return adata
+}
+
+func (mi *ModelItem[model]) idQuery(c *fiber.Ctx, id string) (M, error) {
+	objectId, err := mi.parseId(id)
+	if err != nil {
+		return nil, opError(400, "id decode error", M{"error": err.Error()})
+	}
+	query, err := mi.scopeQuery(c)
+	if err != nil {
+		return nil, opError(403, err.Error(), nil)
+	}
+	query["_id"] = objectId
+	return query, nil
+}
+
+func (mi *ModelItem[model]) listLimit(requested int64) int64 {
+	limit := int64(10)
+	if mi.responseLimit > 0 {
+		limit = mi.responseLimit
+	}
+	if !mi.LimitNoChange && requested != 0 {
+		limit = requested
+	}
+	return limit
+}
+
+func (mi *ModelItem[model]) findOne(c *fiber.Ctx, id string) (any, error) {
+	query, err := mi.idQuery(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if mi.SoftDelete {
+		query["is_deleted"] = false
+	}
+	respItem := reflect.New(mi.model.(reflect.Type)).Interface()
+	cursor, err := mi.findCursor(TxContext(c), query, nil, nil, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c.Context())
+	if !cursor.Next(c.Context()) {
+		return nil, opError(404, "item not found", nil)
+	}
+	err = cursor.Decode(respItem)
+	if err != nil {
+		return nil, err
+	}
+	mi.applyComputed(reflect.ValueOf(respItem))
+	return respItem, nil
+}
+
+func (mi *ModelItem[model]) listFilter(c *fiber.Ctx, list ListQuery) (M, M, error) {
+	query, err := mi.scopeQuery(c)
+	if err != nil {
+		return nil, nil, opError(403, err.Error(), nil)
+	}
+	if mi.SoftDelete {
+		query["is_deleted"] = false
+	}
+	computedFilters, err := mi.typedFilters(func(name string) string {
+		return list.Filters[name]
+	})
+	if err != nil {
+		return nil, nil, opError(400, err.Error(), nil)
+	}
+	return query, computedFilters, nil
+}
+
+func (mi *ModelItem[model]) countMany(c *fiber.Ctx, list ListQuery) (int64, error) {
+	query, computedFilters, err := mi.listFilter(c, list)
+	if err != nil {
+		return 0, err
+	}
+	return mi.countFiltered(TxContext(c), query, computedFilters)
+}
+
+func (mi *ModelItem[model]) findMany(c *fiber.Ctx, list ListQuery) (reflect.Value, error) {
+	query, computedFilters, err := mi.listFilter(c, list)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	sort, err := mi.querySort(list.Sort)
+	if err != nil {
+		return reflect.Value{}, opError(400, err.Error(), nil)
+	}
+	cursor, err := mi.findCursor(TxContext(c), query, computedFilters, sort, list.Offset, mi.listLimit(list.Limit))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	respItems := reflect.New(reflect.SliceOf(mi.model.(reflect.Type)))
+	err = cursor.All(c.Context(), respItems.Interface())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for i := 0; i < respItems.Elem().Len(); i++ {
+		mi.applyComputed(respItems.Elem().Index(i))
+	}
+	return respItems.Elem(), nil
+}
+
+func (mi *ModelItem[model]) insertOne(c *fiber.Ctx, adata M) (any, error) {
+	requestedId := adata["id"]
+	delete(adata, "id")
+	mi.stripComputed(adata)
+	err := mi.scopeTenantInsert(c, adata)
+	if errors.Is(err, ErrTenantScope) {
+		return nil, opError(403, err.Error(), nil)
+	} else if err != nil {
+		return nil, opError(400, err.Error(), nil)
+	}
+	after, err := mi.insertDoc(c, adata, requestedId)
+	if errors.Is(err, ErrInvalidId) {
+		return nil, opError(400, err.Error(), nil)
+	} else if isDuplicateKey(err) {
+		return nil, opError(409, "item already exists", nil)
+	} else if err != nil {
+		return nil, opError(500, "internal server error", err.Error())
+	}
+	respItem := reflect.New(mi.model.(reflect.Type)).Interface()
+	err = bson.Unmarshal(after, respItem)
+	if err != nil {
+		return nil, opError(500, "internal server error", err.Error())
+	}
+	mi.applyComputed(reflect.ValueOf(respItem))
+	return respItem, nil
+}
+
+func (mi *ModelItem[model]) insertDoc(c *fiber.Ctx, adata M, requestedId any) (bson.Raw, error) {
+	if mi.SoftDelete {
+		adata["is_deleted"] = false
+	}
+	if mi.Versioned {
+		adata["version"] = int64(1)
+	}
+	_, after, err := mi.runWrite(c, ChangeInsert, func(ctx context.Context) (bson.Raw, bson.Raw, error) {
+		item := copyM(adata)
+		if mi.UpdateOnAddFunction != nil {
+			var err error
+			item, err = mi.UpdateOnAddFunction(item, c)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		id, err := mi.newId(ctx, item, requestedId)
+		if err != nil {
+			return nil, nil, err
+		}
+		item["_id"] = id
+		insertId, err := mi.colDb.Insert(ctx, item)
+		for isDuplicateKey(err) && mi.GetIdStrategy() == IdSlug {
+			slug, slugErr := mi.newSlug(ctx, item)
+			if slugErr != nil {
+				return nil, nil, slugErr
+			}
+			if slug == item["_id"] {
+				return nil, nil, ErrDuplicateKey
+			}
+			item["_id"] = slug
+			insertId, err = mi.colDb.Insert(ctx, item)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		after, err := mi.colDb.FindOne(ctx, M{"_id": insertId})
+		return nil, after, err
+	})
+	return after, err
+}
+
+func (mi *ModelItem[model]) updateOne(c *fiber.Ctx, id string, adata M) (any, error) {
+	query, err := mi.idQuery(c, id)
+	if err != nil {
+		return nil, err
+	}
+	objectId := query["_id"]
+	delete(adata, "id")
+	mi.stripComputed(adata)
+	delete(adata, "is_deleted")
+	err = mi.scopeTenantUpdate(c, adata)
+	if err != nil {
+		return nil, opError(400, err.Error(), nil)
+	}
+	if mi.SoftDelete {
+		query["is_deleted"] = false
+	}
+	update := M{}
+	if mi.Versioned {
+		version, ok := adata["version"].(float64)
+		if !ok {
+			return nil, opError(400, "version required", nil)
+		}
+		delete(adata, "version")
+		query["version"] = int64(version)
+		update["$inc"] = M{"version": 1}
+	}
+	if len(adata) == 0 && len(update) == 0 {
+		return nil, opError(400, "nothing to update", nil)
+	}
+	pnm := mi.model.(reflect.Type)
+	_, after, err := mi.runWrite(c, ChangeUpdate, func(ctx context.Context) (bson.Raw, bson.Raw, error) {
+		item := copyM(adata)
+		if mi.UpdateOnUpdateFunction != nil {
+			var err error
+			item, err = mi.UpdateOnUpdateFunction(item, c)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		delete(update, "$set")
+		if len(item) > 0 {
+			update["$set"] = item
+		}
+		before, err := mi.colDb.Update(ctx, query, update, UpdateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		after, err := mi.colDb.FindOne(ctx, M{"_id": objectId})
+		return before, after, err
+	})
+	if err == ErrNoDocuments {
+		if mi.Versioned {
+			delete(query, "version")
+			current, err := mi.colDb.FindOne(TxContext(c), query)
+			if err == nil {
+				respItem := reflect.New(pnm).Interface()
+				err = bson.Unmarshal(current, respItem)
+				if err != nil {
+					return nil, opError(500, "internal server error", err.Error())
+				}
+				return nil, opError(409, "version conflict", respItem)
+			}
+		}
+		return nil, opError(404, "item not found", nil)
+	}
+	if err != nil {
+		return nil, opError(500, "internal server error", err.Error())
+	}
+	respItem := reflect.New(pnm).Interface()
+	err = bson.Unmarshal(after, respItem)
+	if err != nil {
+		return nil, opError(500, "internal server error", err.Error())
+	}
+	mi.applyComputed(reflect.ValueOf(respItem))
+	return respItem, nil
+}
+
+func (mi *ModelItem[model]) versionedUpdate(update M) M {
+	if mi.Versioned {
+		update["$inc"] = M{"version": 1}
+	}
+	return update
+}
+
+func (mi *ModelItem[model]) deleteOne(c *fiber.Ctx, id string) error {
+	query, err := mi.idQuery(c, id)
+	if err != nil {
+		return err
+	}
+	objectId := query["_id"]
+	_, _, err = mi.runWrite(c, ChangeDelete, func(ctx context.Context) (bson.Raw, bson.Raw, error) {
+		if mi.SoftDelete {
+			query["is_deleted"] = false
+			before, err := mi.colDb.Update(ctx, query, mi.versionedUpdate(M{"$set": M{"is_deleted": true}}), UpdateOptions{})
+			if err != nil {
+				return nil, nil, err
+			}
+			after, err := mi.colDb.FindOne(ctx, M{"_id": objectId})
+			return before, after, err
+		}
+		before, err := mi.colDb.Delete(ctx, query)
+		return before, nil, err
+	})
+	if err == ErrNoDocuments {
+		return opError(400, "item already deleted or cant found", nil)
+	}
+	return err
+}
+
+func (mi *ModelItem[model]) restoreOne(c *fiber.Ctx, id string) (any, error) {
+	query, err := mi.idQuery(c, id)
+	if err != nil {
+		return nil, err
+	}
+	objectId := query["_id"]
+	query["is_deleted"] = true
+	_, after, err := mi.runWrite(c, ChangeRestore, func(ctx context.Context) (bson.Raw, bson.Raw, error) {
+		before, err := mi.colDb.Update(ctx, query, mi.versionedUpdate(M{"$set": M{"is_deleted": false}}), UpdateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		after, err := mi.colDb.FindOne(ctx, M{"_id": objectId})
+		return before, after, err
+	})
+	if err == ErrNoDocuments {
+		return nil, opError(404, "deleted item not found", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	respItem := reflect.New(mi.model.(reflect.Type)).Interface()
+	err = bson.Unmarshal(after, respItem)
+	if err != nil {
+		return nil, err
+	}
+	mi.applyComputed(reflect.ValueOf(respItem))
+	return respItem, nil
+}
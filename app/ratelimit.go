@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByAPIKey = "apikey"
+	RateLimitByAuth   = "auth"
+)
+
+type RateLimit struct {
+	Requests     int
+	Window       time.Duration
+	KeyBy        string
+	APIKeyHeader string
+	AuthField    string
+}
+
+type RateLimitStore interface {
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+type memoryRateLimitWindow struct {
+	count int
+	reset time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	windows map[string]*memoryRateLimitWindow
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows: map[string]*memoryRateLimitWindow{},
+	}
+}
+
+func (ms *MemoryRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	reset := now.Truncate(window).Add(window)
+	item, ok := ms.windows[key]
+	if !ok || !item.reset.Equal(reset) {
+		for wkey, witem := range ms.windows {
+			if !now.Before(witem.reset) {
+				delete(ms.windows, wkey)
+			}
+		}
+		item = &memoryRateLimitWindow{reset: reset}
+		ms.windows[key] = item
+	}
+	item.count++
+	return item.count, item.reset, nil
+}
+
+type MongoRateLimitStore struct {
+	col *mongo.Collection
+}
+
+func NewMongoRateLimitStore(db *mongo.Database) *MongoRateLimitStore {
+	col := db.Collection("fimapi_rate_limit")
+	duration := int32(0)
+	_, err := col.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: M{"reset": 1},
+		Options: &options.IndexOptions{
+			ExpireAfterSeconds: &duration,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &MongoRateLimitStore{col: col}
+}
+
+func (ms *MongoRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	start := now.Truncate(window)
+	reset := start.Add(window)
+	var item struct {
+		Count int `bson:"count"`
+	}
+	err := ms.col.FindOneAndUpdate(ctx,
+		M{"_id": fmt.Sprintf("%s:%d", key, start.Unix())},
+		M{
+			"$inc":         M{"count": 1},
+			"$setOnInsert": M{"reset": primitive.NewDateTimeFromTime(reset)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&item)
+	if err != nil {
+		return 0, reset, err
+	}
+	return item.Count, reset, nil
+}
+
+func (app *App) SetRateLimitStore(store RateLimitStore) {
+	app.rateLimitStore = store
+}
+
+func (app *App) rateLimitKey(c *fiber.Ctx, end *EndPoint) string {
+	limit := end.RateLimit
+	value := c.IP()
+	switch limit.KeyBy {
+	case RateLimitByAPIKey:
+		header := limit.APIKeyHeader
+		if header == "" {
+			header = "X-API-Key"
+		}
+		if apiKey := c.Get(header); apiKey != "" {
+			value = apiKey
+		}
+	case RateLimitByAuth:
+		if authQuery, ok := c.Locals("authQuery").(M); ok {
+			if limit.AuthField != "" {
+				if field, ok := authQuery[limit.AuthField]; ok {
+					value = fmt.Sprint(field)
+				}
+			} else {
+				scope, _ := json.Marshal(authQuery)
+				value = string(scope)
+			}
+		}
+	}
+	hash := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("%s:%s:%s:%s", c.Method(), c.Route().Path, limit.KeyBy, hex.EncodeToString(hash[:]))
+}
+
+func (app *App) rateLimitHandler(end *EndPoint, fnc func(*fiber.Ctx) error) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		limit := end.RateLimit
+		if limit == nil || limit.Requests <= 0 || limit.Window <= 0 {
+			return fnc(c)
+		}
+		count, reset, err := app.rateLimitStore.Increment(c.Context(), app.rateLimitKey(c, end), limit.Window)
+		if err != nil {
+			return err
+		}
+		remaining := limit.Requests - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		resetSeconds := int(time.Until(reset).Seconds() + 0.5)
+		c.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+		c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds())))
+		if count > limit.Requests {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(resetSeconds))
+			return RError(c, fiber.StatusTooManyRequests, "too many requests", nil)
+		}
+		return fnc(c)
+	}
+}
@@ -0,0 +1,77 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Quote struct {
+	Text string
+}
+
+type recordingLimitStore struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (rs *recordingLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.counts[key]++
+	return rs.counts[key], time.Now().Add(window), nil
+}
+
+func TestRateLimitPerAPIKey(t *testing.T) {
+	h := apptest.New(t)
+	limits := &recordingLimitStore{counts: map[string]int{}}
+	h.App.SetRateLimitStore(limits)
+	quotes := app.NewModel[Quote]("quote")
+	quotes.RateLimit = &app.RateLimit{Requests: 2, Window: time.Minute, KeyBy: app.RateLimitByAPIKey}
+	h.Register(quotes)
+	client := h.As(app.M{})
+	first := client.WithHeader("X-API-Key", "secret-one")
+	second := client.WithHeader("X-API-Key", "secret-two")
+
+	first.Get("/api/quote/").AssertStatus(200)
+	resp := first.Get("/api/quote/").AssertStatus(200)
+	if resp.Header.Get("RateLimit-Remaining") != "0" || resp.Header.Get("RateLimit-Limit") != "2" {
+		t.Fatalf("unexpected rate limit headers %v", resp.Header)
+	}
+	resp = first.Get("/api/quote/").AssertStatus(429)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After on a limited response")
+	}
+	second.Get("/api/quote/").AssertStatus(200)
+	first.Post("/api/quote/", app.M{"text": "hi"}).AssertStatus(201)
+
+	if len(limits.counts) != 3 {
+		t.Fatalf("expected separate counters per key and route, got %v", limits.counts)
+	}
+	for key := range limits.counts {
+		if strings.Contains(key, "secret") {
+			t.Fatalf("expected the api key to be hashed, got %s", key)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreUsesFixedWindows(t *testing.T) {
+	store := app.NewMemoryRateLimitStore()
+	window := time.Hour
+	count, reset, err := store.Increment(context.Background(), "key", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || !reset.Equal(reset.Truncate(window)) || time.Until(reset) > window {
+		t.Fatalf("expected the window to end on a %s boundary, got %s", window, reset)
+	}
+	count, again, _ := store.Increment(context.Background(), "key", window)
+	if again.Equal(reset) && count != 2 {
+		t.Fatalf("expected the second request to share the window, got %d", count)
+	}
+}
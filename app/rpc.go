@@ -0,0 +1,417 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const rpcCallKey = "rpc_call"
+
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000
+)
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcMethod struct {
+	name       string
+	httpMethod string
+	route      string
+	endpoint   *EndPoint
+	model      ModelInterface
+}
+
+func rpcMethodName(path string) string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || strings.HasPrefix(part, ":") {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (app *App) addRPCMethod(httpMethod string, route string, end *EndPoint, model ModelInterface) error {
+	name := end.RPCMethod
+	if name == "-" {
+		return nil
+	}
+	if name == "" {
+		name = rpcMethodName(route)
+		if _, ok := app.rpcMethods[name]; ok {
+			name = fmt.Sprintf("%s.%s", name, strings.ToLower(httpMethod))
+		}
+	}
+	if _, ok := app.rpcMethods[name]; ok {
+		return fmt.Errorf("duplicate rpc method %s", name)
+	}
+	if !strings.HasPrefix(route, "/") {
+		route = "/" + route
+	}
+	app.rpcMethods[name] = &rpcMethod{
+		name:       name,
+		httpMethod: httpMethod,
+		route:      route,
+		endpoint:   end,
+		model:      model,
+	}
+	return nil
+}
+
+func (app *App) registerRPC() error {
+	app.rpcMethods = map[string]*rpcMethod{}
+	for _, item := range app.models {
+		groups := []struct {
+			method    string
+			endpoints []*EndPoint
+		}{
+			{fiber.MethodGet, item.GetEndPoints()},
+			{fiber.MethodPost, item.PostEndPoints()},
+			{fiber.MethodPut, item.PutEndPoints()},
+			{fiber.MethodDelete, item.DeleteEndPoints()},
+		}
+		for _, group := range groups {
+			for _, end := range group.endpoints {
+				err := app.addRPCMethod(group.method, "/api/"+end.path, end, item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, end := range app.GetEndPoints {
+		err := app.addRPCMethod(fiber.MethodGet, end.path, end, nil)
+		if err != nil {
+			return err
+		}
+	}
+	for _, end := range app.PostEndPoints {
+		err := app.addRPCMethod(fiber.MethodPost, end.path, end, nil)
+		if err != nil {
+			return err
+		}
+	}
+	end := app.RegisterPostEndpoint("/rpc", true, nil, nil, app.rpcEndpoint)
+	end.Description = "JSON-RPC 2.0 endpoint for registered operations"
+	return nil
+}
+
+func rpcFailure(id json.RawMessage, code int, message string, data any) *rpcResponse {
+	return &rpcResponse{
+		JSONRPC: "2.0",
+		Error:   &RPCError{Code: code, Message: message, Data: data},
+		Id:      id,
+	}
+}
+
+func rpcErrorCode(status int) int {
+	switch {
+	case status == 400 || status == 422:
+		return RPCInvalidParams
+	case status >= 500:
+		return RPCInternalError
+	}
+	return RPCServerError
+}
+
+func (app *App) rpcEndpoint(c *fiber.Ctx) error {
+	body := bytes.TrimSpace(c.Body())
+	if len(body) == 0 || !json.Valid(body) {
+		return c.JSON(rpcFailure(nil, RPCParseError, "Parse error", nil))
+	}
+	if body[0] != '[' {
+		resp := app.rpcCall(c, body)
+		if resp == nil {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		return c.JSON(resp)
+	}
+	var items []json.RawMessage
+	json.Unmarshal(body, &items)
+	if len(items) == 0 {
+		return c.JSON(rpcFailure(nil, RPCInvalidRequest, "Invalid Request", nil))
+	}
+	responses := []*rpcResponse{}
+	for _, item := range items {
+		if resp := app.rpcCall(c, item); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.JSON(responses)
+}
+
+func (app *App) rpcCall(c *fiber.Ctx, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcFailure(nil, RPCInvalidRequest, "Invalid Request", nil)
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(nil, RPCInvalidRequest, "Invalid Request", nil)
+	}
+	if len(req.Id) > 0 && req.Id[0] != '"' && req.Id[0] != 'n' && req.Id[0] != '-' && (req.Id[0] < '0' || req.Id[0] > '9') {
+		return rpcFailure(nil, RPCInvalidRequest, "Invalid Request", nil)
+	}
+	resp := app.rpcExecute(c, req)
+	if req.Id == nil {
+		return nil
+	}
+	resp.Id = req.Id
+	return resp
+}
+
+func (app *App) rpcExecute(c *fiber.Ctx, req rpcRequest) *rpcResponse {
+	method, ok := app.rpcMethods[req.Method]
+	if !ok {
+		return rpcFailure(nil, RPCMethodNotFound, "Method not found", nil)
+	}
+	params := M{}
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return rpcFailure(nil, RPCInvalidParams, "Invalid params", "params must be an object")
+		}
+	}
+	var segments []string
+	for _, part := range strings.Split(method.route, "/") {
+		if strings.HasPrefix(part, ":") {
+			name := strings.TrimRight(strings.TrimPrefix(part, ":"), "?+")
+			value, ok := params[name]
+			if !ok {
+				return rpcFailure(nil, RPCInvalidParams, "Invalid params", fmt.Sprintf("%s required", name))
+			}
+			delete(params, name)
+			part = url.PathEscape(rpcParamString(value))
+		}
+		segments = append(segments, part)
+	}
+	var request fasthttp.Request
+	c.Request().Header.CopyTo(&request.Header)
+	request.Header.Del("Idempotency-Key")
+	request.Header.SetMethod(method.httpMethod)
+	request.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	uri := strings.Join(segments, "/")
+	switch method.httpMethod {
+	case fiber.MethodGet, fiber.MethodDelete:
+		query := url.Values{}
+		for key, value := range params {
+			if value != nil {
+				query.Set(key, rpcParamString(value))
+			}
+		}
+		if len(query) > 0 {
+			uri = uri + "?" + query.Encode()
+		}
+		request.ResetBody()
+	default:
+		bb, _ := json.Marshal(params)
+		request.Header.SetContentType(fiber.MIMEApplicationJSON)
+		request.SetBody(bb)
+	}
+	request.SetRequestURI(uri)
+	var rctx fasthttp.RequestCtx
+	rctx.Init(&request, c.Context().RemoteAddr(), nil)
+	rctx.SetUserValue(rpcCallKey, true)
+	app.rpcDispatch(&rctx)
+	return rpcResult(rctx.Response.StatusCode(), rctx.Response.Body())
+}
+
+func rpcParamString(value any) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	bb, _ := json.Marshal(value)
+	return string(bb)
+}
+
+func rpcResult(status int, body []byte) *rpcResponse {
+	var resp Response
+	var probe M
+	if json.Unmarshal(body, &probe) == nil && probe["status_code"] != nil {
+		json.Unmarshal(body, &resp)
+	} else if json.Valid(body) {
+		resp.Result = json.RawMessage(body)
+		resp.Error = json.RawMessage(body)
+	} else {
+		resp.Result = string(body)
+		resp.Error = string(body)
+	}
+	if status >= 400 {
+		message := resp.Message
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		return rpcFailure(nil, rpcErrorCode(status), message, M{"status": status, "error": resp.Error})
+	}
+	result, err := json.Marshal(resp.Result)
+	if err != nil {
+		return rpcFailure(nil, RPCInternalError, "Internal error", err.Error())
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result}
+}
+
+func (gd *GenerateDoc) rpcParams(method *rpcMethod) []M {
+	params := []M{}
+	seen := map[string]bool{}
+	add := func(name string, schema M, required bool) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		params = append(params, M{"name": name, "required": required, "schema": schema})
+	}
+	for _, part := range strings.Split(method.route, "/") {
+		if !strings.HasPrefix(part, ":") {
+			continue
+		}
+		name := strings.TrimRight(strings.TrimPrefix(part, ":"), "?+")
+		schema := M{"type": "string"}
+		if method.model != nil && name == "id" {
+			if method.model.GetIdStrategy() == IdSequence {
+				schema = M{"type": "integer"}
+			}
+			if format := idFormat(method.model.GetIdStrategy()); format != "" {
+				schema["format"] = format
+			}
+		}
+		add(name, schema, true)
+	}
+	end := method.endpoint
+	var props M
+	switch {
+	case end.requestbody != nil:
+		props = gd.DocTagsCustom(end.requestbody)
+	case method.model != nil && end.List:
+		props = gd.DocTagsCustom(DefaultQuery{})
+		filters := filterFieldNames(method.model.GetModelType().(reflect.Type))
+		for key, val := range gd.DocTags(method.model) {
+			if typ, ok := val.(M)["type"].(string); ok && filters[key] && typ != "array" && typ != "object" {
+				props[key] = val
+			}
+		}
+	case method.model != nil && (method.httpMethod == fiber.MethodPut || method.httpMethod == fiber.MethodPost && !strings.Contains(method.route, ":")):
+		props = gd.DocTags(method.model)
+	}
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(key, props[key].(M), false)
+	}
+	return params
+}
+
+func (gd *GenerateDoc) rpcResultSchema(method *rpcMethod) M {
+	end := method.endpoint
+	if method.model != nil && !end.IsAggregade {
+		if method.httpMethod == fiber.MethodDelete {
+			return M{}
+		}
+		ref := M{"$ref": fmt.Sprintf("#/components/schemas/%s", method.model.GetName())}
+		if end.List {
+			return M{
+				"type": "object",
+				"properties": M{
+					"total": M{"type": "integer"},
+					"start": M{"type": "integer"},
+					"items": M{"type": "array", "items": ref},
+				},
+			}
+		}
+		return ref
+	}
+	if end.responseModel == nil {
+		return M{}
+	}
+	schema := gd.DocSchema(reflect.TypeOf(end.responseModel))
+	if end.IsAggregade {
+		return M{"type": "array", "items": schema}
+	}
+	return schema
+}
+
+func (gd *GenerateDoc) GenerateOpenRPC() {
+	names := make([]string, 0, len(gd.app.rpcMethods))
+	for name := range gd.app.rpcMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	methods := []M{}
+	for _, name := range names {
+		method := gd.app.rpcMethods[name]
+		summary := method.endpoint.Description
+		if summary == "" {
+			summary = fmt.Sprintf("%s %s", method.httpMethod, method.route)
+		}
+		item := M{
+			"name":    name,
+			"summary": summary,
+			"params":  gd.rpcParams(method),
+			"result": M{
+				"name":   "result",
+				"schema": gd.rpcResultSchema(method),
+			},
+		}
+		if !method.endpoint.IsPublic {
+			item["x-security"] = []M{{"bearerAuth": []M{}}}
+		}
+		methods = append(methods, item)
+	}
+	gd.rpcData = M{
+		"openrpc": "1.2.6",
+		"info": M{
+			"version": "1.0",
+			"title":   gd.app.Name,
+		},
+		"servers": []M{
+			M{
+				"name": gd.app.Name,
+				"url":  gd.app.BaseURL + "/rpc",
+			},
+		},
+		"methods": methods,
+		"components": M{
+			"schemas": gd.schemas,
+		},
+	}
+}
+
+func (gd *GenerateDoc) OpenRPCResponse(c *fiber.Ctx) error {
+	return c.JSON(gd.rpcData)
+}
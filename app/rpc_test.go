@@ -0,0 +1,28 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDuplicateRPCMethodFailsStartup(t *testing.T) {
+	api := app.NewWithStore(apptest.NewMemoryStore(), t.TempDir())
+	api.RPC = true
+	handler := func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	}
+	api.RegisterGetEndpoint("/status", true, nil, nil, handler).RPCMethod = "status"
+	api.RegisterGetEndpoint("/health/status", true, nil, nil, handler).RPCMethod = "status"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := api.Start(ctx, "127.0.0.1:0")
+	if err == nil || !strings.Contains(err.Error(), "duplicate rpc method status") {
+		t.Fatalf("expected a duplicate rpc method error, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrNoDocuments  = mongo.ErrNoDocuments
+	ErrDuplicateKey = errors.New("duplicate key")
+	ErrNotSupported = errors.New("operation not supported by store")
+)
+
+func isDuplicateKey(err error) bool {
+	return errors.Is(err, ErrDuplicateKey) || mongo.IsDuplicateKeyError(err)
+}
+
+type FindOptions struct {
+	Sort  M
+	Skip  int64
+	Limit int64
+}
+
+type UpdateOptions struct {
+	Upsert      bool
+	ReturnAfter bool
+}
+
+type Cursor interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	All(ctx context.Context, results interface{}) error
+	Close(ctx context.Context) error
+	Err() error
+}
+
+type ChangeStream interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	ResumeToken() bson.Raw
+	Close(ctx context.Context) error
+}
+
+type StoreCollection interface {
+	Find(ctx context.Context, filter M, opt FindOptions) (Cursor, error)
+	FindOne(ctx context.Context, filter M) (bson.Raw, error)
+	Count(ctx context.Context, filter M) (int64, error)
+	Insert(ctx context.Context, doc M) (interface{}, error)
+	Update(ctx context.Context, filter M, update M, opt UpdateOptions) (bson.Raw, error)
+	Delete(ctx context.Context, filter M) (bson.Raw, error)
+	Aggregate(ctx context.Context, pipeline []M) (Cursor, error)
+	Watch(ctx context.Context, pipeline []M, resume bson.Raw) (ChangeStream, error)
+}
+
+type Store interface {
+	Collection(name string) StoreCollection
+	Transaction(ctx context.Context, fnc func(ctx context.Context) error) error
+}
+
+type MongoStore struct {
+	Database *mongo.Database
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{Database: db}
+}
+
+func (ms *MongoStore) Collection(name string) StoreCollection {
+	return &mongoCollection{col: ms.Database.Collection(name)}
+}
+
+func (ms *MongoStore) Ping(ctx context.Context) error {
+	return ms.Database.Client().Ping(ctx, readpref.Primary())
+}
+
+func (ms *MongoStore) Transaction(ctx context.Context, fnc func(ctx context.Context) error) error {
+	session, err := ms.Database.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fnc(sc)
+	})
+	return err
+}
+
+type mongoCollection struct {
+	col *mongo.Collection
+}
+
+func (mc *mongoCollection) Find(ctx context.Context, filter M, opt FindOptions) (Cursor, error) {
+	findOpt := options.Find().SetSkip(opt.Skip).SetLimit(opt.Limit)
+	if opt.Sort != nil {
+		findOpt.SetSort(opt.Sort)
+	}
+	return mc.col.Find(ctx, filter, findOpt)
+}
+
+func (mc *mongoCollection) FindOne(ctx context.Context, filter M) (bson.Raw, error) {
+	return mc.col.FindOne(ctx, filter).DecodeBytes()
+}
+
+func (mc *mongoCollection) Count(ctx context.Context, filter M) (int64, error) {
+	return mc.col.CountDocuments(ctx, filter)
+}
+
+func (mc *mongoCollection) Insert(ctx context.Context, doc M) (interface{}, error) {
+	result, err := mc.col.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	return result.InsertedID, nil
+}
+
+func (mc *mongoCollection) Update(ctx context.Context, filter M, update M, opt UpdateOptions) (bson.Raw, error) {
+	updateOpt := options.FindOneAndUpdate().SetUpsert(opt.Upsert)
+	if opt.ReturnAfter {
+		updateOpt.SetReturnDocument(options.After)
+	}
+	return mc.col.FindOneAndUpdate(ctx, filter, update, updateOpt).DecodeBytes()
+}
+
+func (mc *mongoCollection) Delete(ctx context.Context, filter M) (bson.Raw, error) {
+	return mc.col.FindOneAndDelete(ctx, filter).DecodeBytes()
+}
+
+func (mc *mongoCollection) Aggregate(ctx context.Context, pipeline []M) (Cursor, error) {
+	return mc.col.Aggregate(ctx, pipeline)
+}
+
+func (mc *mongoCollection) Watch(ctx context.Context, pipeline []M, resume bson.Raw) (ChangeStream, error) {
+	opt := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resume != nil {
+		opt.SetResumeAfter(resume)
+	}
+	if pipeline == nil {
+		pipeline = []M{}
+	}
+	return mc.col.Watch(ctx, pipeline, opt)
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var ErrTenantScope = errors.New("tenant scope required")
+
+func (mi *ModelItem[model]) GetTenantField() string {
+	return mi.TenantField
+}
+func (mi *ModelItem[model]) SetTenantCollections(collections map[string]string) {
+	mi.tenantCollections = collections
+}
+
+func (mi *ModelItem[model]) scopeQuery(c *fiber.Ctx) (M, error) {
+	query := M{}
+	if extraQuery, ok := c.Locals("authQuery").(M); ok {
+		for key, val := range extraQuery {
+			query[key] = val
+		}
+	}
+	if mi.TenantField != "" {
+		if _, ok := query[mi.TenantField]; !ok {
+			return nil, ErrTenantScope
+		}
+	}
+	return query, nil
+}
+
+func (mi *ModelItem[model]) bodyHasField(c *fiber.Ctx, field string) bool {
+	if strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEApplicationJSON) {
+		var raw M
+		if json.Unmarshal(c.Body(), &raw) == nil {
+			_, ok := raw[field]
+			return ok
+		}
+		return false
+	}
+	return c.FormValue(field) != ""
+}
+
+func (mi *ModelItem[model]) scopeTenantInsert(c *fiber.Ctx, adata M) error {
+	if mi.TenantField == "" {
+		return nil
+	}
+	query, err := mi.scopeQuery(c)
+	if err != nil {
+		return err
+	}
+	if mi.bodyHasField(c, mi.TenantField) {
+		return errors.New(mi.TenantField + " can not be set by client")
+	}
+	adata[mi.TenantField] = query[mi.TenantField]
+	return nil
+}
+
+func (mi *ModelItem[model]) scopeTenantUpdate(c *fiber.Ctx, adata M) error {
+	if mi.TenantField == "" {
+		return nil
+	}
+	if mi.bodyHasField(c, mi.TenantField) {
+		return errors.New(mi.TenantField + " can not be changed by client")
+	}
+	delete(adata, mi.TenantField)
+	return nil
+}
+
+type lookupScope struct {
+	collections map[string]string
+	tenantField string
+}
+
+func (mi *ModelItem[model]) scopeLookups(pipeline []M, query M) ([]M, error) {
+	return lookupScope{collections: mi.tenantCollections, tenantField: mi.TenantField}.scopeLookups(pipeline, query)
+}
+
+func (ls lookupScope) scopeLookups(pipeline []M, query M) ([]M, error) {
+	if ls.collections == nil {
+		return pipeline, nil
+	}
+	scoped := make([]M, len(pipeline))
+	for i := range pipeline {
+		stage, err := ls.scopeLookupStage(pipeline[i], query)
+		if err != nil {
+			return nil, err
+		}
+		scoped[i] = stage
+	}
+	return scoped, nil
+}
+
+func (ls lookupScope) lookupTenant(query M, field string) (interface{}, error) {
+	key := field
+	if ls.tenantField != "" {
+		key = ls.tenantField
+	}
+	value, ok := query[key]
+	if !ok {
+		return nil, ErrTenantScope
+	}
+	return value, nil
+}
+
+func (ls lookupScope) scopeSubPipeline(items interface{}, query M) ([]interface{}, error) {
+	var pipeline []interface{}
+	switch val := items.(type) {
+	case []interface{}:
+		pipeline = append(pipeline, val...)
+	case []M:
+		for _, item := range val {
+			pipeline = append(pipeline, item)
+		}
+	}
+	for i := range pipeline {
+		if inner, ok := asMap(pipeline[i]); ok {
+			stage, err := ls.scopeLookupStage(inner, query)
+			if err != nil {
+				return nil, err
+			}
+			pipeline[i] = stage
+		}
+	}
+	return pipeline, nil
+}
+
+func (ls lookupScope) scopeLookupStage(stage map[string]interface{}, query M) (M, error) {
+	scoped := M{}
+	for name, body := range stage {
+		scoped[name] = body
+		if coll, ok := body.(string); ok && name == "$unionWith" {
+			body = M{"coll": coll}
+		}
+		original, ok := asMap(body)
+		if !ok {
+			continue
+		}
+		spec := copyM(original)
+		from, _ := spec["from"].(string)
+		if name == "$unionWith" {
+			from, _ = spec["coll"].(string)
+		}
+		field, isScoped := ls.collections[from]
+		var tenant interface{}
+		if isScoped {
+			var err error
+			tenant, err = ls.lookupTenant(query, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch name {
+		case "$lookup", "$unionWith":
+			pipeline, err := ls.scopeSubPipeline(spec["pipeline"], query)
+			if err != nil {
+				return nil, err
+			}
+			if isScoped {
+				pipeline = append([]interface{}{M{"$match": M{field: tenant}}}, pipeline...)
+			}
+			if len(pipeline) > 0 {
+				spec["pipeline"] = pipeline
+			}
+		case "$graphLookup":
+			if isScoped {
+				restrict := M{}
+				if current, ok := asMap(spec["restrictSearchWithMatch"]); ok {
+					restrict = copyM(current)
+				}
+				restrict[field] = tenant
+				spec["restrictSearchWithMatch"] = restrict
+			}
+		case "$facet":
+			for key, items := range spec {
+				pipeline, err := ls.scopeSubPipeline(items, query)
+				if err != nil {
+					return nil, err
+				}
+				spec[key] = pipeline
+			}
+		}
+		scoped[name] = spec
+	}
+	return scoped, nil
+}
+
+func asMap(item interface{}) (map[string]interface{}, bool) {
+	switch val := item.(type) {
+	case M:
+		return val, true
+	case map[string]interface{}:
+		return val, true
+	}
+	return nil, false
+}
@@ -0,0 +1,138 @@
+package app_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Note struct {
+	Title  string
+	Tenant string
+}
+
+type Board struct {
+	Title  string
+	Tenant string
+}
+
+type NoteCount struct {
+	Id    string `json:"_id" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+type BoardNotes struct {
+	Title string `json:"title" bson:"title"`
+	Notes []Note `json:"notes" bson:"notes"`
+}
+
+type tenantNote struct {
+	Id     string `json:"id"`
+	Title  string `json:"title"`
+	Tenant string `json:"tenant"`
+}
+
+func tenantHarness(t *testing.T) (*apptest.Harness, *apptest.Client, *apptest.Client, string) {
+	h := apptest.New(t)
+	notes := app.NewModel[Note]("note")
+	notes.TenantField = "tenant"
+	notes.SoftDelete = true
+	notes.AddAggrageEndPoint("note_count", "get", NoteCount{}, struct{}{}, []app.M{
+		{"$group": app.M{"_id": "$tenant", "count": app.M{"$sum": 1}}},
+	})
+	boards := app.NewModel[Board]("board")
+	boards.AddAggrageEndPoint("board_notes", "get", BoardNotes{}, struct{}{}, []app.M{
+		{"$lookup": app.M{"from": "note", "localField": "title", "foreignField": "title", "as": "notes"}},
+		{"$unionWith": app.M{"coll": "note"}},
+	})
+	h.Register(notes, boards)
+
+	tenantA := h.As(app.M{"tenant": "a"})
+	tenantB := h.As(app.M{"tenant": "b"})
+	var created tenantNote
+	tenantA.Post("/api/note/", app.M{"title": "shared"}).AssertStatus(201).Result(&created)
+	if created.Tenant != "a" {
+		t.Fatalf("expected note to be stamped with tenant a, got %q", created.Tenant)
+	}
+	return h, tenantA, tenantB, created.Id
+}
+
+func TestTenantCannotReadOtherTenant(t *testing.T) {
+	_, tenantA, tenantB, id := tenantHarness(t)
+
+	tenantB.Get("/api/note/" + id).AssertStatus(404)
+	tenantA.Get("/api/note/" + id).AssertStatus(200)
+
+	var list struct {
+		Items []tenantNote `json:"items"`
+	}
+	tenantB.Get("/api/note/").AssertStatus(200).Result(&list)
+	if len(list.Items) != 0 {
+		t.Fatalf("tenant b listed %d notes of tenant a", len(list.Items))
+	}
+	tenantA.Get("/api/note/").AssertStatus(200).Result(&list)
+	if len(list.Items) != 1 {
+		t.Fatalf("expected tenant a to list its note, got %d", len(list.Items))
+	}
+}
+
+func TestTenantCannotWriteOtherTenant(t *testing.T) {
+	h, tenantA, tenantB, id := tenantHarness(t)
+
+	tenantB.Put("/api/note/"+id, app.M{"title": "taken"}).AssertStatus(404)
+	tenantB.Delete("/api/note/" + id).AssertStatus(400)
+	tenantA.Delete("/api/note/" + id).AssertStatus(200)
+	tenantB.Post("/api/note/"+id+"/restore", nil).AssertStatus(404)
+	tenantA.Post("/api/note/"+id+"/restore", nil).AssertStatus(200)
+
+	for _, doc := range h.Documents(app.NewModel[Note]("note")) {
+		if doc["title"] != "shared" || doc["tenant"] != "a" {
+			t.Fatalf("tenant b changed tenant a's note: %v", doc)
+		}
+	}
+}
+
+func TestTenantFieldCannotBeSetByClient(t *testing.T) {
+	_, tenantA, tenantB, id := tenantHarness(t)
+
+	tenantB.Post("/api/note/", app.M{"title": "forged", "tenant": "a"}).AssertStatus(400)
+	tenantA.Put("/api/note/"+id, app.M{"title": "moved", "tenant": "b"}).AssertStatus(400)
+	tenantA.Get("/api/note/" + id).AssertStatus(200)
+}
+
+func TestTenantRequiresScope(t *testing.T) {
+	h, _, _, id := tenantHarness(t)
+
+	anonymous := h.As(app.M{})
+	anonymous.Get("/api/note/" + id).AssertStatus(403)
+	anonymous.Get("/api/note/").AssertStatus(403)
+	anonymous.Post("/api/note/", app.M{"title": "orphan"}).AssertStatus(403)
+	anonymous.Get("/api/note_count").AssertStatus(403)
+}
+
+func TestTenantAggregateIsScoped(t *testing.T) {
+	_, _, tenantB, _ := tenantHarness(t)
+
+	var counts []app.M
+	tenantB.Get("/api/note_count").AssertStatus(200).Result(&counts)
+	if len(counts) != 0 {
+		t.Fatalf("tenant b aggregated tenant a's notes: %v", counts)
+	}
+}
+
+func TestTenantLookupFromUnscopedModel(t *testing.T) {
+	h, _, tenantB, _ := tenantHarness(t)
+	h.SeedCollection("board", app.M{"_id": primitive.NewObjectID(), "title": "shared", "tenant": "b"})
+
+	var boards []app.M
+	tenantB.Get("/api/board_notes").AssertStatus(200).Result(&boards)
+	if len(boards) != 1 {
+		t.Fatalf("expected only tenant b's board, got %v", boards)
+	}
+	if notes := fmt.Sprint(boards[0]["notes"]); notes != "[]" {
+		t.Fatalf("lookup leaked tenant a's notes: %s", notes)
+	}
+}
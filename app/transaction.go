@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	txContextKey     = "txContext"
+	txAfterCommitKey = "txAfterCommit"
+)
+
+var errTransactionAborted = errors.New("transaction aborted")
+
+func TxContext(c *fiber.Ctx) context.Context {
+	if ctx, ok := c.Locals(txContextKey).(context.Context); ok {
+		return ctx
+	}
+	return c.Context()
+}
+
+func afterCommit(c *fiber.Ctx, fnc func()) {
+	if queue, ok := c.Locals(txAfterCommitKey).(*[]func()); ok {
+		*queue = append(*queue, fnc)
+		return
+	}
+	fnc()
+}
+
+func runTransaction(c *fiber.Ctx, store Store, fnc func(ctx context.Context) error) error {
+	if ctx, ok := c.Locals(txContextKey).(context.Context); ok {
+		return fnc(ctx)
+	}
+	var panicked interface{}
+	var committed []func()
+	err := store.Transaction(c.Context(), func(ctx context.Context) (err error) {
+		committed = nil
+		c.Locals(txContextKey, ctx)
+		c.Locals(txAfterCommitKey, &committed)
+		defer c.Locals(txContextKey, nil)
+		defer c.Locals(txAfterCommitKey, nil)
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = r
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		panicked = nil
+		return fnc(ctx)
+	})
+	if panicked != nil {
+		panic(panicked)
+	}
+	if err == nil {
+		for _, fnc := range committed {
+			fnc()
+		}
+	}
+	return err
+}
+
+func (app *App) WithTransaction(c *fiber.Ctx, fnc func(ctx context.Context) error) error {
+	return runTransaction(c, app.store, fnc)
+}
+
+func (app *App) transactionHandler(fnc func(*fiber.Ctx) error) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		err := app.WithTransaction(c, func(ctx context.Context) error {
+			err := fnc(c)
+			if err != nil {
+				return err
+			}
+			if c.Response().StatusCode() >= fiber.StatusBadRequest {
+				return errTransactionAborted
+			}
+			return nil
+		})
+		if err == errTransactionAborted {
+			return nil
+		}
+		return err
+	}
+}
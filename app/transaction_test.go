@@ -0,0 +1,199 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errTransient = errors.New("transient transaction error")
+
+type retryStore struct {
+	*apptest.MemoryStore
+}
+
+func (rs retryStore) Transaction(ctx context.Context, fnc func(ctx context.Context) error) error {
+	err := rs.MemoryStore.Transaction(ctx, func(ctx context.Context) error {
+		if err := fnc(ctx); err != nil {
+			return err
+		}
+		return errTransient
+	})
+	if err != errTransient {
+		return err
+	}
+	return rs.MemoryStore.Transaction(ctx, fnc)
+}
+
+type Ticket struct {
+	Title string
+}
+
+func TestTransactionRetryRunsHooksOnFreshInput(t *testing.T) {
+	store := retryStore{apptest.NewMemoryStore()}
+	dapp := app.NewWithStore(store, t.TempDir())
+	tickets := app.NewModel[Ticket]("ticket")
+	tickets.Transactional = true
+	tickets.UpdateOnAdd(func(item app.M, c *fiber.Ctx) (app.M, error) {
+		item["title"] = item["title"].(string) + "!"
+		return item, nil
+	})
+	dapp.RegisterModel(tickets)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/ticket/", strings.NewReader(`{"title":"open"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := dapp.Build().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.StatusCode)
+	}
+	docs := store.Documents("ticket")
+	if len(docs) != 1 || docs[0]["title"] != "open!" {
+		t.Fatalf("expected one ticket titled open!, got %v", docs)
+	}
+}
+
+type countingStore struct {
+	*apptest.MemoryStore
+	transactions int
+}
+
+func (cs *countingStore) Transaction(ctx context.Context, fnc func(ctx context.Context) error) error {
+	cs.transactions++
+	return cs.MemoryStore.Transaction(ctx, fnc)
+}
+
+func TestTransactionalGetWithCache(t *testing.T) {
+	store := &countingStore{MemoryStore: apptest.NewMemoryStore()}
+	dapp := app.NewWithStore(store, t.TempDir())
+	dapp.SetCache(app.NewMemoryCache(16))
+	end := dapp.RegisterGetEndpoint("/report", true, nil, nil, func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	end.Transactional = true
+	end.CacheTTL = time.Minute
+	fapp := dapp.Build()
+
+	for _, cache := range []string{"MISS", "HIT"} {
+		resp, err := fapp.Test(httptest.NewRequest(fiber.MethodGet, "/report", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("X-Cache"); got != cache {
+			t.Fatalf("expected cache %s, got %q", cache, got)
+		}
+	}
+	if store.transactions != 1 {
+		t.Fatalf("expected the cache miss to run in one transaction, got %d", store.transactions)
+	}
+}
+
+type Ledger struct {
+	Title string
+}
+
+func TestAbortedTransactionSkipsChangeNotifications(t *testing.T) {
+	store := apptest.NewMemoryStore()
+	dapp := app.NewWithStore(store, t.TempDir())
+	ledgers := app.NewModel[Ledger]("ledger")
+	changes := 0
+	ledgers.OnChange(func(event *app.ChangeEvent) {
+		changes++
+	})
+	dapp.RegisterModel(ledgers)
+	end := dapp.RegisterPostEndpoint("/ledger/book", true, nil, nil, func(c *fiber.Ctx) error {
+		err := ledgers.CreateItem(c)
+		if err != nil {
+			return err
+		}
+		if changes != 0 {
+			t.Error("expected change notifications to wait for the commit")
+		}
+		if c.Query("reject") != "" {
+			return c.Status(fiber.StatusConflict).SendString("rejected")
+		}
+		return nil
+	})
+	end.Transactional = true
+	fapp := dapp.Build()
+
+	for _, path := range []string{"/ledger/book?reject=1", "/ledger/book"} {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(`{"title":"entry"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		if _, err := fapp.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if docs := store.Documents("ledger"); len(docs) != 1 {
+		t.Fatalf("expected only the committed entry, got %v", docs)
+	}
+	if changes != 1 {
+		t.Fatalf("expected one change notification for the committed entry, got %d", changes)
+	}
+}
+
+func bookLedger(t *testing.T, store app.Store, path string) (*apptest.EventRecorder, int) {
+	dapp := app.NewWithStore(store, t.TempDir())
+	events := apptest.NewEventRecorder()
+	dapp.SetEventBus(events)
+	ledgers := app.NewModel[Ledger]("ledger")
+	dapp.RegisterModel(ledgers)
+	end := dapp.RegisterPostEndpoint("/ledger/book", true, nil, nil, func(c *fiber.Ctx) error {
+		err := ledgers.CreateItem(c)
+		if err != nil {
+			return err
+		}
+		if len(events.Events()) != 0 {
+			t.Error("expected events to wait for the commit")
+		}
+		if c.Query("reject") != "" {
+			return c.Status(fiber.StatusConflict).SendString("rejected")
+		}
+		return nil
+	})
+	end.Transactional = true
+	req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(`{"title":"entry"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := dapp.Build().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events, resp.StatusCode
+}
+
+func TestAbortedTransactionEmitsNoEvents(t *testing.T) {
+	store := apptest.NewMemoryStore()
+	events, code := bookLedger(t, store, "/ledger/book?reject=1")
+	if code != fiber.StatusConflict {
+		t.Fatalf("expected status 409, got %d", code)
+	}
+	if docs := store.Documents("ledger"); len(docs) != 0 {
+		t.Fatalf("expected the write to be rolled back, got %v", docs)
+	}
+	if got := len(events.Events()); got != 0 {
+		t.Fatalf("expected no events from an aborted transaction, got %d", got)
+	}
+}
+
+func TestRetriedTransactionEmitsEventsOnce(t *testing.T) {
+	store := retryStore{apptest.NewMemoryStore()}
+	events, code := bookLedger(t, store, "/ledger/book")
+	if code != fiber.StatusCreated {
+		t.Fatalf("expected status 201, got %d", code)
+	}
+	if docs := store.Documents("ledger"); len(docs) != 1 {
+		t.Fatalf("expected one committed ledger entry, got %v", docs)
+	}
+	if got := len(events.Filter("Ledger", app.EventCreated)); got != 1 {
+		t.Fatalf("expected one created event after the retry, got %d", got)
+	}
+}
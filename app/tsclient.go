@@ -0,0 +1,411 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stoewer/go-strcase"
+)
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+type tsClientGen struct {
+	doc *GenerateDoc
+}
+
+func tsPropertyName(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func tsRefName(ref string) string {
+	return strings.TrimPrefix(ref, "#/components/schemas/")
+}
+
+func (g *tsClientGen) tsType(schema M, indent string) string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return tsRefName(ref)
+	}
+	var typ string
+	if all, ok := schema["allOf"].([]M); ok && len(all) > 0 {
+		typ = g.tsType(all[0], indent)
+	} else {
+		switch schema["type"] {
+		case "string":
+			typ = "string"
+		case "integer", "number":
+			typ = "number"
+		case "boolean":
+			typ = "boolean"
+		case "array":
+			items, _ := schema["items"].(M)
+			typ = g.tsType(items, indent)
+			if strings.Contains(typ, "|") {
+				typ = "(" + typ + ")"
+			}
+			typ += "[]"
+		case "object":
+			if props, ok := schema["properties"].(M); ok {
+				required, _ := schema["required"].([]string)
+				typ = g.tsObject(props, required, indent)
+			} else if additional, ok := schema["additionalProperties"].(M); ok {
+				typ = fmt.Sprintf("Record<string, %s>", g.tsType(additional, indent))
+			} else {
+				typ = "Record<string, unknown>"
+			}
+		default:
+			typ = "unknown"
+		}
+	}
+	if nullable, _ := schema["nullable"].(bool); nullable {
+		typ += " | null"
+	}
+	return typ
+}
+
+func (g *tsClientGen) tsObject(props M, required []string, indent string) string {
+	if len(props) == 0 {
+		return "Record<string, unknown>"
+	}
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, key := range keys {
+		schema, _ := props[key].(M)
+		readonly := ""
+		if ro, _ := schema["readOnly"].(bool); ro {
+			readonly = "readonly "
+		}
+		optional := "?"
+		for _, name := range required {
+			if name == key {
+				optional = ""
+			}
+		}
+		fmt.Fprintf(&b, "%s  %s%s%s: %s;\n", indent, readonly, tsPropertyName(key), optional, g.tsType(schema, indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (g *tsClientGen) typeOf(value any) string {
+	return g.tsType(g.doc.DocSchema(reflect.TypeOf(value)), "")
+}
+
+func tsPath(route string) (string, []string) {
+	var args []string
+	parts := []string{}
+	for _, part := range strings.Split(route, "/") {
+		if strings.HasPrefix(part, ":") {
+			arg := strcase.LowerCamelCase(strings.TrimRight(strings.TrimPrefix(part, ":"), "?+"))
+			args = append(args, arg)
+			part = fmt.Sprintf("${encodeURIComponent(String(%s))}", arg)
+		}
+		parts = append(parts, part)
+	}
+	return "`" + strings.Join(parts, "/") + "`", args
+}
+
+func (g *tsClientGen) endpointMethod(target string, item clientEndpoint) string {
+	end := item.endpoint
+	path, args := tsPath(item.route)
+	var params []string
+	for _, arg := range args {
+		params = append(params, arg+": string")
+	}
+	query := "undefined"
+	body := "undefined"
+	if end.requestbody != nil {
+		if item.httpMethod == "GET" {
+			params = append(params, "query?: "+g.tsObject(g.doc.DocQueryData(reflect.TypeOf(end.requestbody)), nil, "  "))
+			query = "query"
+		} else {
+			params = append(params, "body: "+g.typeOf(end.requestbody))
+			body = "body"
+		}
+	}
+	out := "unknown"
+	if end.responseModel != nil {
+		out = g.typeOf(end.responseModel)
+		if end.IsAggregade {
+			out += "[]"
+		}
+	}
+	return fmt.Sprintf("  %s(%s): Promise<%s> {\n    return %s.request(%q, %s, %s, %s);\n  }\n",
+		strcase.LowerCamelCase(item.name), strings.Join(params, ", "), out, target, item.httpMethod, path, query, body)
+}
+
+func (g *tsClientGen) listQuery(model ModelInterface) string {
+	props := g.doc.DocTagsCustom(DefaultQuery{})
+	filters := filterFieldNames(model.GetModelType().(reflect.Type))
+	for key, val := range g.doc.DocTags(model) {
+		if typ, ok := val.(M)["type"].(string); ok && filters[key] && typ != "array" && typ != "object" {
+			props[key] = val
+		}
+	}
+	return g.tsObject(props, nil, "")
+}
+
+func (g *tsClientGen) modelService(model ModelInterface, types *strings.Builder) (string, string) {
+	name := schemaNameCleaner.ReplaceAllString(model.GetName(), "")
+	service := name + "Service"
+	route := "/api/" + strcase.SnakeCase(model.GetName()) + "/"
+	itemRoute := fmt.Sprintf("`%s${encodeURIComponent(String(id))}`", route)
+	idType := "string"
+	if model.GetIdStrategy() == IdSequence {
+		idType = "number"
+	}
+	access := modelAccessOf(model)
+	var b strings.Builder
+	fmt.Fprintf(&b, "export class %s {\n  constructor(private readonly client: Client) {}\n\n", service)
+	if access.List {
+		fmt.Fprintf(types, "export interface %sListQuery %s\n\n", name, g.listQuery(model))
+		fmt.Fprintf(types, "export interface %sList {\n  total: number;\n  start: number;\n  items: %s[];\n}\n\n", name, name)
+		fmt.Fprintf(&b, "  list(query?: %sListQuery): Promise<%sList> {\n    return this.client.request(\"GET\", %q, query);\n  }\n\n", name, name, route)
+	}
+	if access.Get {
+		fmt.Fprintf(&b, "  get(id: %s): Promise<%s> {\n    return this.client.request(\"GET\", %s);\n  }\n\n", idType, name, itemRoute)
+	}
+	if access.Insert {
+		fmt.Fprintf(&b, "  create(item: Partial<%s>): Promise<%s> {\n    return this.client.request(\"POST\", %q, undefined, item);\n  }\n\n", name, name, route)
+	}
+	if access.Update {
+		fmt.Fprintf(&b, "  update(id: %s, item: Partial<%s>): Promise<%s> {\n    return this.client.request(\"PUT\", %s, undefined, item);\n  }\n\n", idType, name, name, itemRoute)
+	}
+	if access.Delete {
+		fmt.Fprintf(&b, "  async delete(id: %s): Promise<void> {\n    await this.client.request(\"DELETE\", %s);\n  }\n\n", idType, itemRoute)
+	}
+	if access.Restore {
+		fmt.Fprintf(&b, "  restore(id: %s): Promise<%s> {\n    return this.client.request(\"POST\", `%s${encodeURIComponent(String(id))}/restore`);\n  }\n\n", idType, name, route)
+	}
+	for _, item := range modelClientEndpoints(model) {
+		b.WriteString(g.endpointMethod("this.client", item))
+		b.WriteString("\n")
+	}
+	return service, strings.TrimRight(b.String(), "\n") + "\n}\n"
+}
+
+func (app *App) GenerateTSClient(pkg string, version string) (map[string][]byte, error) {
+	successMode, errorMode, err := clientEnvelopeModes(app)
+	if err != nil {
+		return nil, err
+	}
+	gd := &GenerateDoc{app: app, schemas: M{}, paths: M{}}
+	g := &tsClientGen{doc: gd}
+	for _, model := range app.models {
+		gd.schemas[schemaNameCleaner.ReplaceAllString(model.GetName(), "")] = gd.objectSchema(model.GetModelType().(reflect.Type))
+	}
+	var types strings.Builder
+	var services strings.Builder
+	var fields [][2]string
+	used := map[string]bool{}
+	for _, model := range app.models {
+		service, code := g.modelService(model, &types)
+		field := strcase.LowerCamelCase(schemaNameCleaner.ReplaceAllString(model.GetName(), ""))
+		fields = append(fields, [2]string{field, service})
+		used[strcase.UpperCamelCase(field)] = true
+		services.WriteString("\n")
+		services.WriteString(code)
+	}
+	var methods strings.Builder
+	for _, item := range app.clientEndpoints(used) {
+		methods.WriteString("\n")
+		methods.WriteString(g.endpointMethod("this", item))
+	}
+	names := make([]string, 0, len(gd.schemas))
+	for name := range gd.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var schemas strings.Builder
+	schemas.WriteString("// Code generated by go-fiber-mapi. DO NOT EDIT.\n\n")
+	for _, name := range names {
+		schema := gd.schemas[name].(M)
+		props, _ := schema["properties"].(M)
+		if len(props) == 0 {
+			fmt.Fprintf(&schemas, "export type %s = Record<string, unknown>;\n\n", name)
+			continue
+		}
+		required, _ := schema["required"].([]string)
+		fmt.Fprintf(&schemas, "export interface %s %s\n\n", name, g.tsObject(props, required, ""))
+	}
+	fmt.Fprintf(&schemas, "export type ApiErrorBody = %s;\n\n", g.tsType(app.envelope().ErrorSchema(), ""))
+	if successMode == "envelope" {
+		schemas.WriteString("export interface ApiResponse<T> {\n  message?: string;\n  status_code?: number;\n  status?: boolean;\n  result?: T;\n}\n\n")
+	}
+	schemas.WriteString(types.String())
+
+	var client strings.Builder
+	client.WriteString("// Code generated by go-fiber-mapi. DO NOT EDIT.\n\n")
+	client.WriteString("import type {\n")
+	for _, name := range names {
+		fmt.Fprintf(&client, "  %s,\n", name)
+	}
+	for _, model := range app.models {
+		name := schemaNameCleaner.ReplaceAllString(model.GetName(), "")
+		if modelAccessOf(model).List {
+			fmt.Fprintf(&client, "  %sList,\n  %sListQuery,\n", name, name)
+		}
+	}
+	client.WriteString("} from \"./types\";\n\n")
+	client.WriteString(tsClientRuntime)
+	client.WriteString(tsClientDecoders[successMode+"_result"])
+	client.WriteString(tsClientDecoders[errorMode+"_error"])
+	client.WriteString("export class Client {\n")
+	for _, field := range fields {
+		fmt.Fprintf(&client, "  readonly %s: %s;\n", field[0], field[1])
+	}
+	client.WriteString("\n  constructor(private readonly options: ClientOptions) {\n")
+	for _, field := range fields {
+		fmt.Fprintf(&client, "    this.%s = new %s(this);\n", field[0], field[1])
+	}
+	client.WriteString("  }\n\n")
+	client.WriteString(tsClientRequest)
+	client.WriteString(methods.String())
+	client.WriteString("}\n")
+	client.WriteString(services.String())
+
+	pkgJSON, err := json.MarshalIndent(M{
+		"name":    pkg,
+		"version": version,
+		"main":    "dist/index.js",
+		"types":   "dist/index.d.ts",
+		"files":   []string{"dist"},
+		"scripts": M{
+			"build":   "tsc",
+			"prepare": "tsc",
+		},
+		"devDependencies": M{
+			"typescript": "^5.0.0",
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tsconfig, err := json.MarshalIndent(M{
+		"compilerOptions": M{
+			"target":       "ES2019",
+			"module":       "commonjs",
+			"lib":          []string{"ES2019", "DOM"},
+			"declaration":  true,
+			"outDir":       "dist",
+			"rootDir":      "src",
+			"strict":       true,
+			"skipLibCheck": true,
+		},
+		"include": []string{"src"},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"package.json":   append(pkgJSON, '\n'),
+		"tsconfig.json":  append(tsconfig, '\n'),
+		"src/types.ts":   []byte(schemas.String()),
+		"src/client.ts":  []byte(client.String()),
+		"src/index.ts":   []byte("// Code generated by go-fiber-mapi. DO NOT EDIT.\n\nexport * from \"./types\";\nexport * from \"./client\";\n"),
+		"src/version.ts": []byte(fmt.Sprintf("// Code generated by go-fiber-mapi. DO NOT EDIT.\n\nexport const VERSION = %q;\n", version)),
+	}, nil
+}
+
+func (app *App) WriteTSClient(dir string, pkg string, version string) error {
+	files, err := app.GenerateTSClient(pkg, version)
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		target := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(target, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const tsClientRuntime = `export interface ClientOptions {
+  baseUrl: string;
+  token?: string;
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+export class ApiError extends Error {
+  constructor(
+    readonly status: number,
+    message: string,
+    readonly data?: unknown,
+  ) {
+    super(message);
+  }
+}
+
+function buildQuery(query?: object): string {
+  if (!query) {
+    return "";
+  }
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(query)) {
+    if (value === undefined || value === null) {
+      continue;
+    }
+    if (Array.isArray(value)) {
+      for (const item of value) {
+        params.append(key, typeof item === "object" ? JSON.stringify(item) : String(item));
+      }
+      continue;
+    }
+    params.set(key, typeof value === "object" ? JSON.stringify(value) : String(value));
+  }
+  const text = params.toString();
+  return text ? "?" + text : "";
+}
+
+`
+
+const tsClientRequest = `  async request<T>(method: string, path: string, query?: object, body?: unknown): Promise<T> {
+    const headers: Record<string, string> = { Accept: "application/json", ...this.options.headers };
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (this.options.token) {
+      headers["Authorization"] = "Bearer " + this.options.token;
+    }
+    const doFetch = this.options.fetch ?? fetch;
+    const response = await doFetch(this.options.baseUrl.replace(/\/+$/, "") + path + buildQuery(query), {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const text = await response.text();
+    const data = text ? JSON.parse(text) : undefined;
+    if (!response.ok) {
+      throw decodeError(response.status, data);
+    }
+    return decodeResult(data) as T;
+  }
+`
+
+var tsClientDecoders = map[string]string{
+	"envelope_result": "function decodeResult(data: any): unknown {\n  return data?.result;\n}\n\n",
+	"bare_result":     "function decodeResult(data: any): unknown {\n  return data;\n}\n\n",
+	"envelope_error":  "function decodeError(status: number, data: any): ApiError {\n  return new ApiError(status, data?.message ?? String(status), data?.error);\n}\n\n",
+	"bare_error":      "function decodeError(status: number, data: any): ApiError {\n  return new ApiError(status, data?.message ?? String(status), data?.error);\n}\n\n",
+	"problem_error":   "function decodeError(status: number, data: any): ApiError {\n  return new ApiError(status, data?.detail ?? data?.title ?? String(status), data?.errors);\n}\n\n",
+}
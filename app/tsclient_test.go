@@ -0,0 +1,62 @@
+package app_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Receipt struct {
+	Number string `json:"number" bson:"number"`
+	Note   string `json:"note,omitempty" bson:"note,omitempty"`
+	Amount int64  `json:"amount" bson:"amount"`
+}
+
+type ReceiptTotal struct {
+	Total int64 `json:"total" bson:"total"`
+}
+
+type receiptRange struct {
+	MinAmount int64 `json:"min_amount" query:"min"`
+}
+
+func generatedTypes(t *testing.T) (string, string) {
+	h := apptest.New(t)
+	receipts := app.NewModel[Receipt]("receipt")
+	receipts.AddAggrageEndPoint("receipt_total", "get", ReceiptTotal{}, receiptRange{}, []app.M{})
+	h.Register(receipts)
+	files, err := h.App.GenerateTSClient("receipts", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(files["src/types.ts"]), string(files["src/client.ts"])
+}
+
+func TestTSClientMarksRequiredFields(t *testing.T) {
+	types, _ := generatedTypes(t)
+
+	for _, want := range []string{"  amount: number;\n", "  number: string;\n", "  note?: string;\n", "  id?: string;\n", "  total: number;\n"} {
+		if !strings.Contains(types, want) {
+			t.Fatalf("expected %q in generated types:\n%s", want, types)
+		}
+	}
+}
+
+func TestTSClientQueryParams(t *testing.T) {
+	types, client := generatedTypes(t)
+
+	query := types[strings.Index(types, "export interface ReceiptListQuery"):]
+	for _, want := range []string{"amount?: number;", "number?: string;", "note?: string;", "limit?: number;"} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in the list query:\n%s", want, query)
+		}
+	}
+	if !strings.Contains(client, "receiptTotal(query?: {\n    min?: number;\n  })") {
+		t.Fatalf("expected aggregate params keyed by query tag:\n%s", client)
+	}
+	if strings.Contains(client, "min_amount") {
+		t.Fatalf("expected json names to stay out of aggregate params:\n%s", client)
+	}
+}
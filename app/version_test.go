@@ -0,0 +1,43 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Article struct {
+	Title string
+}
+
+type articleResult struct {
+	Id      string `json:"id"`
+	Title   string `json:"title"`
+	Version int64  `json:"version"`
+}
+
+func TestVersionBumpsOnDeleteAndRestore(t *testing.T) {
+	h := apptest.New(t)
+	articles := app.NewModel[Article]("article")
+	articles.Versioned = true
+	articles.SoftDelete = true
+	h.Register(articles)
+	client := h.As(app.M{})
+
+	var created articleResult
+	client.Post("/api/article/", app.M{"title": "draft"}).AssertStatus(201).Result(&created)
+	client.Delete("/api/article/" + created.Id).AssertStatus(200)
+	var restored articleResult
+	client.Post("/api/article/"+created.Id+"/restore", nil).AssertStatus(200).Result(&restored)
+	if restored.Version != 3 {
+		t.Fatalf("expected delete and restore to bump the version to 3, got %d", restored.Version)
+	}
+
+	client.Put("/api/article/"+created.Id, app.M{"title": "stale", "version": created.Version}).AssertStatus(409)
+	var updated articleResult
+	client.Put("/api/article/"+created.Id, app.M{"title": "fresh", "version": restored.Version}).AssertStatus(200).Result(&updated)
+	if updated.Version != 4 || updated.Title != "fresh" {
+		t.Fatalf("expected fresh at version 4, got %+v", updated)
+	}
+}
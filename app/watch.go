@@ -0,0 +1,374 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	ChangeInsert  = "insert"
+	ChangeUpdate  = "update"
+	ChangeDelete  = "delete"
+	ChangeRestore = "restore"
+)
+
+const localTokenPrefix = "local-"
+
+type ChangeEvent struct {
+	Token      string    `json:"token"`
+	Operation  string    `json:"operation"`
+	Model      string    `json:"model"`
+	DocumentId string    `json:"document_id"`
+	Document   any       `json:"document,omitempty"`
+	Time       time.Time `json:"time"`
+	scope      M
+}
+
+type watchHub struct {
+	mu     sync.Mutex
+	seq    int64
+	size   int
+	buffer []*ChangeEvent
+	subs   map[chan *ChangeEvent]struct{}
+}
+
+func newWatchHub(size int) *watchHub {
+	return &watchHub{
+		size: size,
+		subs: map[chan *ChangeEvent]struct{}{},
+	}
+}
+
+func (wh *watchHub) publish(event *ChangeEvent) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.seq++
+	event.Token = localTokenPrefix + strconv.FormatInt(wh.seq, 10)
+	wh.buffer = append(wh.buffer, event)
+	if len(wh.buffer) > wh.size {
+		wh.buffer = wh.buffer[len(wh.buffer)-wh.size:]
+	}
+	for sub := range wh.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+func (wh *watchHub) subscribe(after string) (chan *ChangeEvent, []*ChangeEvent, func()) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	var backlog []*ChangeEvent
+	if seq, err := strconv.ParseInt(strings.TrimPrefix(after, localTokenPrefix), 10, 64); err == nil && strings.HasPrefix(after, localTokenPrefix) {
+		for _, event := range wh.buffer {
+			if eseq, _ := strconv.ParseInt(strings.TrimPrefix(event.Token, localTokenPrefix), 10, 64); eseq > seq {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	sub := make(chan *ChangeEvent, 64)
+	wh.subs[sub] = struct{}{}
+	return sub, backlog, func() {
+		wh.mu.Lock()
+		defer wh.mu.Unlock()
+		delete(wh.subs, sub)
+	}
+}
+
+func matchesFilter(doc M, filter M) bool {
+	for key, val := range filter {
+		if fmt.Sprint(doc[key]) != fmt.Sprint(val) {
+			return false
+		}
+	}
+	return true
+}
+
+func (mi *ModelItem[model]) changeFromRaw(operation string, raw bson.Raw) *ChangeEvent {
+	event := &ChangeEvent{
+		Operation: operation,
+		Model:     mi.name,
+		Time:      time.Now(),
+	}
+	if raw == nil {
+		return event
+	}
+	var scope M
+	if bson.Unmarshal(raw, &scope) == nil {
+		event.scope = scope
+		event.DocumentId = idString(scope["_id"])
+	}
+	doc := reflect.New(mi.model.(reflect.Type)).Interface()
+	if bson.Unmarshal(raw, doc) == nil {
+		event.Document = doc
+	}
+	return event
+}
+
+func (mi *ModelItem[model]) OnChange(fnc func(*ChangeEvent)) {
+	mi.changeListeners = append(mi.changeListeners, fnc)
+}
+
+func (mi *ModelItem[model]) publishChange(operation string, raw bson.Raw) {
+	if mi.hub == nil && len(mi.changeListeners) == 0 {
+		return
+	}
+	event := mi.changeFromRaw(operation, raw)
+	for _, fnc := range mi.changeListeners {
+		fnc(event)
+	}
+	if mi.hub != nil {
+		mi.hub.publish(event)
+	}
+}
+
+func (mi *ModelItem[model]) watchFilter(c *fiber.Ctx) (M, error) {
+	filter, err := mi.scopeQuery(c)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := mi.typedFilters(func(name string) string {
+		return c.Query(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range mi.computedExpressions() {
+		if _, ok := filters[name]; ok {
+			return nil, fmt.Errorf("filter %s is not supported on watch streams", name)
+		}
+	}
+	for key, val := range filters {
+		if _, ok := filter[key]; !ok {
+			filter[key] = val
+		}
+	}
+	return filter, nil
+}
+
+func (mi *ModelItem[model]) watchStream(ctx context.Context, filter M, resume string) (ChangeStream, error) {
+	var token bson.Raw
+	if resume != "" && !strings.HasPrefix(resume, localTokenPrefix) {
+		raw, err := base64.RawURLEncoding.DecodeString(resume)
+		if err != nil {
+			return nil, err
+		}
+		token = bson.Raw(raw)
+	}
+	var pipeline []M
+	if len(filter) > 0 {
+		after := M{"operationType": M{"$in": []string{"insert", "update", "replace"}}}
+		before := M{"operationType": "delete"}
+		for key, val := range filter {
+			after["fullDocument."+key] = val
+			before["fullDocumentBeforeChange."+key] = val
+		}
+		pipeline = append(pipeline, M{"$match": M{"$or": []M{after, before}}})
+	}
+	return mi.colDb.Watch(ctx, pipeline, token)
+}
+
+// Watch streams changes matching filter. Inserts and updates are matched on the
+// document after the change; deletes on a Mongo change stream are matched on
+// the pre-image, so scoped subscribers only receive hard deletes when
+// changeStreamPreAndPostImages is enabled on the collection.
+func (mi *ModelItem[model]) Watch(ctx context.Context, filter M, resume string) <-chan *ChangeEvent {
+	out := make(chan *ChangeEvent, 64)
+	stream, err := mi.watchStream(ctx, filter, resume)
+	if err == nil {
+		go func() {
+			defer close(out)
+			defer stream.Close(context.Background())
+			for stream.Next(ctx) {
+				var change struct {
+					OperationType            string   `bson:"operationType"`
+					DocumentKey              M        `bson:"documentKey"`
+					FullDocument             bson.Raw `bson:"fullDocument"`
+					FullDocumentBeforeChange bson.Raw `bson:"fullDocumentBeforeChange"`
+				}
+				if stream.Decode(&change) != nil {
+					continue
+				}
+				var event *ChangeEvent
+				switch change.OperationType {
+				case "insert":
+					event = mi.changeFromRaw(ChangeInsert, change.FullDocument)
+				case "update", "replace":
+					event = mi.changeFromRaw(ChangeUpdate, change.FullDocument)
+					if deleted, ok := event.scope["is_deleted"].(bool); ok && deleted && mi.SoftDelete {
+						event.Operation = ChangeDelete
+					}
+				case "delete":
+					event = mi.changeFromRaw(ChangeDelete, change.FullDocumentBeforeChange)
+					if event.DocumentId == "" {
+						event.DocumentId = idString(change.DocumentKey["_id"])
+					}
+				default:
+					continue
+				}
+				event.Token = base64.RawURLEncoding.EncodeToString(stream.ResumeToken())
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	}
+	if mi.hub == nil {
+		close(out)
+		return out
+	}
+
+	sub, backlog, cancel := mi.hub.subscribe(resume)
+	go func() {
+		defer close(out)
+		defer cancel()
+		send := func(event *ChangeEvent) bool {
+			if !matchesFilter(event.scope, filter) {
+				return true
+			}
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, event := range backlog {
+			if !send(event) {
+				return
+			}
+		}
+		for {
+			select {
+			case event := <-sub:
+				if !send(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (mi *ModelItem[model]) SetBaseContext(ctx context.Context) {
+	mi.baseCtx = ctx
+}
+
+func (mi *ModelItem[model]) streamContext() (context.Context, context.CancelFunc) {
+	if mi.baseCtx == nil {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithCancel(mi.baseCtx)
+}
+
+func (mi *ModelItem[model]) WatchSSE(c *fiber.Ctx) error {
+	filter, err := mi.watchFilter(c)
+	if errors.Is(err, ErrTenantScope) {
+		return mi.RError(c, 403, err.Error(), nil)
+	} else if err != nil {
+		return mi.R400(c, err.Error(), nil)
+	}
+	resume := c.Get("Last-Event-ID", c.Query("resume_after"))
+	ctx, cancel := mi.streamContext()
+	events := mi.Watch(ctx, filter, resume)
+	conn := c.Context().Conn()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		ticker := time.NewTicker(time.Second * 15)
+		defer ticker.Stop()
+		for {
+			conn.SetWriteDeadline(time.Now().Add(time.Second * 30))
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Token, event.Operation, data)
+			case <-ticker.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (mi *ModelItem[model]) WatchWebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	filter, err := mi.watchFilter(c)
+	if errors.Is(err, ErrTenantScope) {
+		return mi.RError(c, 403, err.Error(), nil)
+	} else if err != nil {
+		return mi.R400(c, err.Error(), nil)
+	}
+	c.Locals("watchFilter", filter)
+	return mi.watchWebSocket(c)
+}
+
+func (mi *ModelItem[model]) watchWebSocketHandler(conn *websocket.Conn) {
+	filter, _ := conn.Locals("watchFilter").(M)
+	ctx, cancel := mi.streamContext()
+	defer cancel()
+	events := mi.Watch(ctx, filter, conn.Query("resume_after"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(time.Minute))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+			if conn.WriteJSON(event) != nil {
+				return
+			}
+		case <-ticker.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*10)) != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookCollection         = "fimapi_webhooks"
+	webhookDeliveryCollection = "fimapi_webhook_deliveries"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type WebhookSubscription struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Model     string             `json:"model" bson:"model"`
+	Events    []string           `json:"events" bson:"events"`
+	URL       string             `json:"url" bson:"url"`
+	Secret    string             `json:"secret,omitempty" bson:"secret"`
+	Disabled  bool               `json:"disabled" bson:"disabled"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+	Owner     string             `json:"-" bson:"owner"`
+	Scope     M                  `json:"-" bson:"scope,omitempty"`
+}
+
+type WebhookAttempt struct {
+	Date         primitive.DateTime `json:"date" bson:"date"`
+	ResponseCode int                `json:"response_code,omitempty" bson:"response_code,omitempty"`
+	Error        string             `json:"error,omitempty" bson:"error,omitempty"`
+	Duration     int64              `json:"duration" bson:"duration"`
+}
+
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionId primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	Event          string             `json:"event" bson:"event"`
+	Model          string             `json:"model" bson:"model"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       []WebhookAttempt   `json:"attempts" bson:"attempts"`
+	NextAttempt    primitive.DateTime `json:"next_attempt" bson:"next_attempt"`
+	CreatedAt      primitive.DateTime `json:"created_at" bson:"created_at"`
+	Owner          string             `json:"-" bson:"owner"`
+}
+
+type webhookPayload struct {
+	Id         string    `json:"id"`
+	Event      string    `json:"event"`
+	Model      string    `json:"model"`
+	DocumentId string    `json:"document_id"`
+	Document   any       `json:"document,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	if attempts >= 12 {
+		return time.Hour
+	}
+	backoff := time.Second * time.Duration(1<<uint(attempts))
+	if backoff > time.Hour {
+		return time.Hour
+	}
+	return backoff
+}
+
+func storeDoc(val interface{}) (M, error) {
+	raw, err := bson.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var doc M
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+func (app *App) WebhookDbInit() error {
+	if app.WebhookMaxAttempts == 0 {
+		app.WebhookMaxAttempts = 8
+	}
+	if app.dbCon == nil {
+		return nil
+	}
+	col := app.dbCon.Collection(webhookDeliveryCollection)
+	_, err := col.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: M{"status": 1, "next_attempt": 1}},
+		{Keys: M{"subscription_id": 1, "created_at": -1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = app.dbCon.Collection(webhookCollection).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: M{"model": 1, "events": 1},
+	})
+	return err
+}
+
+func (app *App) SetWebhookAuthorizer(fnc func(*fiber.Ctx) error) {
+	app.webhookAuthorizer = fnc
+}
+
+func (app *App) webhookOwner(c *fiber.Ctx) (string, M) {
+	scope, _ := c.Locals("authQuery").(M)
+	owner, _ := json.Marshal(scope)
+	return string(owner), scope
+}
+
+func (app *App) webhookAdmin(fnc func(*fiber.Ctx) error) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		err := app.webhookAuthorizer(c)
+		if err != nil {
+			return RError(c, fiber.StatusForbidden, "Forbidden", err.Error())
+		}
+		return fnc(c)
+	}
+}
+
+func (app *App) handleChange(event *ChangeEvent) {
+	if app.Webhooks {
+		app.background(func() {
+			app.enqueueWebhooks(event)
+		})
+	}
+}
+
+func (app *App) enqueueWebhooks(event *ChangeEvent) {
+	name, ok := changeEventTypes[event.Operation]
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	cursor, err := app.store.Collection(webhookCollection).Find(ctx, M{
+		"model":    event.Model,
+		"events":   name,
+		"disabled": false,
+	}, FindOptions{})
+	if err != nil {
+		app.errorLogger.Error("webhook subscriptions", zap.Error(err))
+		return
+	}
+	var subscriptions []WebhookSubscription
+	err = cursor.All(ctx, &subscriptions)
+	if err != nil {
+		app.errorLogger.Error("webhook subscriptions", zap.Error(err))
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	for _, sub := range subscriptions {
+		if !matchesFilter(event.scope, sub.Scope) {
+			continue
+		}
+		delivery := WebhookDelivery{
+			Id:             primitive.NewObjectID(),
+			SubscriptionId: sub.Id,
+			Event:          name,
+			Model:          event.Model,
+			Status:         DeliveryPending,
+			Attempts:       []WebhookAttempt{},
+			NextAttempt:    now,
+			CreatedAt:      now,
+			Owner:          sub.Owner,
+		}
+		payload, _ := json.Marshal(webhookPayload{
+			Id:         delivery.Id.Hex(),
+			Event:      name,
+			Model:      event.Model,
+			DocumentId: event.DocumentId,
+			Document:   event.Document,
+			Time:       event.Time,
+		})
+		delivery.Payload = string(payload)
+		doc, err := storeDoc(delivery)
+		if err == nil {
+			_, err = app.store.Collection(webhookDeliveryCollection).Insert(ctx, doc)
+		}
+		if err != nil {
+			app.errorLogger.Error("webhook enqueue", zap.Error(err))
+		}
+	}
+}
+
+func (app *App) webhookWorker(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for app.deliverNextWebhook(ctx) {
+			}
+		}
+	}
+}
+
+func (app *App) deliverNextWebhook(ctx context.Context) bool {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	col := app.store.Collection(webhookDeliveryCollection)
+	cursor, err := col.Find(ctx, M{
+		"status":       M{"$in": []string{DeliveryPending, DeliverySending}},
+		"next_attempt": M{"$lte": now},
+	}, FindOptions{Sort: M{"next_attempt": 1}, Limit: 1})
+	if err != nil {
+		app.errorLogger.Error("webhook queue", zap.Error(err))
+		return false
+	}
+	var due []WebhookDelivery
+	err = cursor.All(ctx, &due)
+	if err != nil || len(due) == 0 {
+		if err != nil {
+			app.errorLogger.Error("webhook queue", zap.Error(err))
+		}
+		return false
+	}
+	delivery := due[0]
+	_, err = col.Update(ctx,
+		M{"_id": delivery.Id, "status": delivery.Status, "next_attempt": delivery.NextAttempt},
+		M{"$set": M{
+			"status":       DeliverySending,
+			"next_attempt": primitive.NewDateTimeFromTime(time.Now().Add(time.Minute)),
+		}},
+		UpdateOptions{},
+	)
+	if err == ErrNoDocuments {
+		return true
+	}
+	if err != nil {
+		app.errorLogger.Error("webhook queue", zap.Error(err))
+		return false
+	}
+	var sub WebhookSubscription
+	raw, err := app.store.Collection(webhookCollection).FindOne(ctx, M{"_id": delivery.SubscriptionId})
+	if err == nil {
+		err = bson.Unmarshal(raw, &sub)
+	}
+	if err != nil || sub.Disabled {
+		col.Update(ctx, M{"_id": delivery.Id}, M{"$set": M{"status": DeliveryDead}}, UpdateOptions{})
+		return true
+	}
+
+	attempt := app.sendWebhook(ctx, &sub, &delivery)
+	update := M{"$push": M{"attempts": attempt}}
+	attempts := len(delivery.Attempts) + 1
+	if attempt.Error == "" {
+		update["$set"] = M{"status": DeliveryDelivered}
+	} else if attempts >= app.WebhookMaxAttempts {
+		update["$set"] = M{"status": DeliveryDead}
+	} else {
+		update["$set"] = M{
+			"status":       DeliveryPending,
+			"next_attempt": primitive.NewDateTimeFromTime(time.Now().Add(webhookBackoff(attempts))),
+		}
+	}
+	_, err = col.Update(ctx, M{"_id": delivery.Id}, update, UpdateOptions{})
+	if err != nil {
+		app.errorLogger.Error("webhook delivery", zap.Error(err))
+	}
+	return true
+}
+
+func (app *App) sendWebhook(ctx context.Context, sub *WebhookSubscription, delivery *WebhookDelivery) WebhookAttempt {
+	start := time.Now()
+	attempt := WebhookAttempt{Date: primitive.NewDateTimeFromTime(start)}
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.Id.Hex())
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", WebhookSignature(sub.Secret, timestamp, body))
+	client := &http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	attempt.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	attempt.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+func (app *App) listWebhooks(c *fiber.Ctx) error {
+	owner, _ := app.webhookOwner(c)
+	query := M{"owner": owner}
+	if model := c.Query("model"); model != "" {
+		query["model"] = model
+	}
+	cursor, err := app.store.Collection(webhookCollection).Find(c.Context(), query, FindOptions{})
+	if err != nil {
+		return err
+	}
+	subscriptions := []WebhookSubscription{}
+	err = cursor.All(c.Context(), &subscriptions)
+	if err != nil {
+		return err
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return ROk(c, 200, "", subscriptions)
+}
+
+func (app *App) createWebhook(c *fiber.Ctx) error {
+	var sub WebhookSubscription
+	err := c.BodyParser(&sub)
+	if err != nil {
+		return RError(c, 400, "body parse error", err.Error())
+	}
+	if sub.Model == "" || sub.URL == "" || len(sub.Events) == 0 {
+		return RError(c, 400, "model, url and events are required", nil)
+	}
+	for _, event := range sub.Events {
+		switch event {
+		case EventCreated, EventUpdated, EventDeleted, EventRestored:
+		default:
+			return RError(c, 400, "unknown event "+event, nil)
+		}
+	}
+	if sub.Secret == "" {
+		sub.Secret, err = webhookSecret()
+		if err != nil {
+			return err
+		}
+	}
+	sub.Owner, sub.Scope = app.webhookOwner(c)
+	sub.Disabled = false
+	sub.Id = primitive.NewObjectID()
+	sub.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	doc, err := storeDoc(sub)
+	if err != nil {
+		return err
+	}
+	_, err = app.store.Collection(webhookCollection).Insert(c.Context(), doc)
+	if err != nil {
+		return err
+	}
+	return ROk(c, 201, "webhook created", sub)
+}
+
+func (app *App) disableWebhook(c *fiber.Ctx) error {
+	objectId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return RError(c, 400, "objectId decode error", nil)
+	}
+	owner, _ := app.webhookOwner(c)
+	_, err = app.store.Collection(webhookCollection).Update(c.Context(), M{"_id": objectId, "owner": owner}, M{"$set": M{"disabled": true}}, UpdateOptions{})
+	if err == ErrNoDocuments {
+		return RError(c, 404, "webhook not found", nil)
+	}
+	if err != nil {
+		return err
+	}
+	return ROk(c, 200, "webhook disabled", nil)
+}
+
+func (app *App) listWebhookDeliveries(c *fiber.Ctx) error {
+	objectId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return RError(c, 400, "objectId decode error", nil)
+	}
+	owner, _ := app.webhookOwner(c)
+	query := M{"subscription_id": objectId, "owner": owner}
+	if status := c.Query("status"); status != "" {
+		query["status"] = status
+	}
+	opt := FindOptions{Sort: M{"created_at": -1}, Limit: 100}
+	cursor, err := app.store.Collection(webhookDeliveryCollection).Find(c.Context(), query, opt)
+	if err != nil {
+		return err
+	}
+	deliveries := []WebhookDelivery{}
+	err = cursor.All(c.Context(), &deliveries)
+	if err != nil {
+		return err
+	}
+	return ROk(c, 200, "", deliveries)
+}
+
+func (app *App) replayWebhookDelivery(c *fiber.Ctx) error {
+	objectId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return RError(c, 400, "objectId decode error", nil)
+	}
+	owner, _ := app.webhookOwner(c)
+	_, err = app.store.Collection(webhookDeliveryCollection).Update(c.Context(), M{"_id": objectId, "owner": owner}, M{"$set": M{
+		"status":       DeliveryPending,
+		"next_attempt": primitive.NewDateTimeFromTime(time.Now()),
+	}}, UpdateOptions{})
+	if err == ErrNoDocuments {
+		return RError(c, 404, "delivery not found", nil)
+	}
+	if err != nil {
+		return err
+	}
+	return ROk(c, 200, "delivery queued", nil)
+}
+
+func (app *App) registerWebhookEndpoints() error {
+	if app.webhookAuthorizer == nil {
+		return errors.New("webhooks require an admin authorizer, see SetWebhookAuthorizer")
+	}
+	end := app.RegisterGetEndpoint("/webhooks/", false, nil, nil, app.webhookAdmin(app.listWebhooks))
+	end.Description = "List webhook subscriptions"
+	end = app.RegisterPostEndpoint("/webhooks/", false, WebhookSubscription{}, WebhookSubscription{}, app.webhookAdmin(app.createWebhook))
+	end.Description = "Create a webhook subscription"
+	end = app.RegisterPostEndpoint("/webhooks/:id/disable", false, nil, nil, app.webhookAdmin(app.disableWebhook))
+	end.Description = "Disable a webhook subscription"
+	end = app.RegisterGetEndpoint("/webhooks/:id/deliveries", false, nil, nil, app.webhookAdmin(app.listWebhookDeliveries))
+	end.Description = "List deliveries of a webhook subscription"
+	end = app.RegisterPostEndpoint("/webhooks/deliveries/:id/replay", false, nil, nil, app.webhookAdmin(app.replayWebhookDelivery))
+	end.Description = "Replay a webhook delivery"
+	return nil
+}
@@ -0,0 +1,161 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Parcel struct {
+	Title  string
+	Tenant string
+}
+
+type webhookCall struct {
+	header http.Header
+	body   []byte
+}
+
+type webhookReceiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status int
+	calls  []webhookCall
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	wr := &webhookReceiver{status: status}
+	wr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		wr.mu.Lock()
+		wr.calls = append(wr.calls, webhookCall{header: r.Header.Clone(), body: body})
+		wr.mu.Unlock()
+		w.WriteHeader(wr.status)
+	}))
+	t.Cleanup(wr.Close)
+	return wr
+}
+
+func (wr *webhookReceiver) received() []webhookCall {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]webhookCall(nil), wr.calls...)
+}
+
+func webhookHarness(t *testing.T) *apptest.Harness {
+	h := apptest.New(t)
+	h.App.Webhooks = true
+	h.App.SetWebhookAuthorizer(func(c *fiber.Ctx) error {
+		return nil
+	})
+	parcels := app.NewModel[Parcel]("parcel")
+	parcels.TenantField = "tenant"
+	h.Register(parcels)
+	return h
+}
+
+func waitFor(t *testing.T, what string, fnc func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 5); !fnc(); time.Sleep(time.Millisecond * 50) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestWebhookDeliveriesAreSigned(t *testing.T) {
+	h := webhookHarness(t)
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	client := h.As(app.M{"tenant": "a"})
+
+	var sub app.WebhookSubscription
+	client.Post("/webhooks/", app.M{"model": "Parcel", "url": receiver.URL, "events": []string{app.EventCreated}}).AssertStatus(201).Result(&sub)
+	if len(sub.Secret) != 64 {
+		t.Fatalf("expected a 32 byte hex secret, got %q", sub.Secret)
+	}
+	client.Post("/api/parcel/", app.M{"title": "parcel"}).AssertStatus(201)
+
+	waitFor(t, "webhook delivery", func() bool {
+		return len(receiver.received()) > 0
+	})
+	call := receiver.received()[0]
+	timestamp := call.header.Get("X-Webhook-Timestamp")
+	if got, want := call.header.Get("X-Webhook-Signature"), app.WebhookSignature(sub.Secret, timestamp, call.body); got != want {
+		t.Fatalf("expected signature %s, got %s", want, got)
+	}
+	if app.WebhookSignature("other", timestamp, call.body) == call.header.Get("X-Webhook-Signature") {
+		t.Fatal("expected signature to depend on the secret")
+	}
+	var payload struct {
+		Event string `json:"event"`
+		Model string `json:"model"`
+	}
+	json.Unmarshal(call.body, &payload)
+	if payload.Event != app.EventCreated || payload.Model != "Parcel" {
+		t.Fatalf("unexpected payload %s", call.body)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	h := webhookHarness(t)
+	h.App.WebhookMaxAttempts = 3
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	client := h.As(app.M{"tenant": "a"})
+
+	client.Post("/webhooks/", app.M{"model": "Parcel", "url": receiver.URL, "events": []string{app.EventCreated}}).AssertStatus(201)
+	client.Post("/api/parcel/", app.M{"title": "parcel"}).AssertStatus(201)
+
+	var delivery app.M
+	waitFor(t, "failed attempt", func() bool {
+		docs := h.Store.Documents("fimapi_webhook_deliveries")
+		if len(docs) == 0 {
+			return false
+		}
+		attempts, _ := docs[0]["attempts"].(primitive.A)
+		delivery = app.M(docs[0])
+		return len(attempts) == 1 && delivery["status"] == app.DeliveryPending
+	})
+	attempt := delivery["attempts"].(primitive.A)[0].(primitive.M)
+	tried := attempt["date"].(primitive.DateTime).Time()
+	next := delivery["next_attempt"].(primitive.DateTime).Time()
+	if wait := next.Sub(tried); wait < time.Second*2 || wait > time.Second*3 {
+		t.Fatalf("expected the first retry about 2s after the attempt, got %s", wait)
+	}
+	if attempt["response_code"] != int32(http.StatusInternalServerError) {
+		t.Fatalf("expected the attempt to record the status, got %v", attempt["response_code"])
+	}
+}
+
+func TestWebhookSubscriptionsAreScoped(t *testing.T) {
+	h := webhookHarness(t)
+	receiverA := newWebhookReceiver(t, http.StatusOK)
+	receiverB := newWebhookReceiver(t, http.StatusOK)
+	tenantA := h.As(app.M{"tenant": "a"})
+	tenantB := h.As(app.M{"tenant": "b"})
+
+	tenantA.Post("/webhooks/", app.M{"model": "Parcel", "url": receiverA.URL, "events": []string{app.EventCreated}}).AssertStatus(201)
+	tenantB.Post("/webhooks/", app.M{"model": "Parcel", "url": receiverB.URL, "events": []string{app.EventCreated}}).AssertStatus(201)
+	tenantA.Post("/api/parcel/", app.M{"title": "for a"}).AssertStatus(201)
+
+	waitFor(t, "tenant a delivery", func() bool {
+		return len(receiverA.received()) > 0
+	})
+	time.Sleep(time.Millisecond * 200)
+	if calls := receiverB.received(); len(calls) != 0 {
+		t.Fatalf("expected tenant b not to receive tenant a events, got %s", calls[0].body)
+	}
+	var subs []app.WebhookSubscription
+	tenantB.Get("/webhooks/").AssertStatus(200).Result(&subs)
+	if len(subs) != 1 || subs[0].URL != receiverB.URL || subs[0].Secret != "" {
+		t.Fatalf("expected tenant b to list only its own subscription without secret, got %+v", subs)
+	}
+}
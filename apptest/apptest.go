@@ -0,0 +1,300 @@
+package apptest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stoewer/go-strcase"
+	"github.com/valyala/fasthttp"
+)
+
+type Harness struct {
+	App        *app.App
+	Store      *MemoryStore
+	Events     *EventRecorder
+	t          testing.TB
+	mu         sync.Mutex
+	principals map[string]app.M
+}
+
+func New(t testing.TB) *Harness {
+	t.Helper()
+	store := NewMemoryStore()
+	h := &Harness{
+		App:        app.NewWithStore(store, t.TempDir()),
+		Store:      store,
+		Events:     NewEventRecorder(),
+		t:          t,
+		principals: map[string]app.M{},
+	}
+	h.App.SetEventBus(h.Events)
+	h.App.SetAuthMiddleware(h.authenticate)
+	return h
+}
+
+func (h *Harness) Register(items ...app.ModelInterface) *Harness {
+	for _, item := range items {
+		h.App.RegisterModel(item)
+	}
+	return h
+}
+
+func (h *Harness) Fiber() *fiber.App {
+	return h.App.Build()
+}
+
+func (h *Harness) authenticate(c *fiber.Ctx) (app.M, error) {
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	h.mu.Lock()
+	principal, ok := h.principals[token]
+	h.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no test principal")
+	}
+	scope := app.M{}
+	for key, val := range principal {
+		scope[key] = val
+	}
+	return scope, nil
+}
+
+func (h *Harness) As(principal app.M) *Client {
+	h.mu.Lock()
+	token := "apptest-" + strconv.Itoa(len(h.principals)+1)
+	h.principals[token] = principal
+	h.mu.Unlock()
+	return h.Client().WithHeader(fiber.HeaderAuthorization, "Bearer "+token)
+}
+
+func (h *Harness) Seed(item app.ModelInterface, docs ...app.M) []interface{} {
+	h.t.Helper()
+	fapp := fiber.New()
+	ctx := fapp.AcquireCtx(&fasthttp.RequestCtx{})
+	defer fapp.ReleaseCtx(ctx)
+	ctx.SetUserContext(context.WithValue(context.Background(), "request_id", "seed"))
+	recorded := len(h.Events.Events())
+	defer h.Events.truncate(recorded)
+	var ids []interface{}
+	for _, doc := range docs {
+		id, err := item.UpsertFixture(ctx, doc)
+		if err != nil {
+			h.t.Fatalf("seed %s: %v", item.GetName(), err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *Harness) SeedCollection(name string, docs ...interface{}) []interface{} {
+	h.t.Helper()
+	col := &memoryCollection{store: h.Store, name: name}
+	h.Store.mu.Lock()
+	defer h.Store.mu.Unlock()
+	var ids []interface{}
+	for _, doc := range docs {
+		item, err := toDoc(doc)
+		if err == nil {
+			var id interface{}
+			id, err = col.insert(item)
+			ids = append(ids, id)
+		}
+		if err != nil {
+			h.t.Fatalf("seed %s: %v", name, err)
+		}
+	}
+	return ids
+}
+
+func (h *Harness) Documents(item app.ModelInterface) []app.M {
+	var out []app.M
+	for _, doc := range h.Store.Documents(strcase.SnakeCase(item.GetName())) {
+		out = append(out, app.M(doc))
+	}
+	return out
+}
+
+func (h *Harness) AssertEvent(model string, eventType string) *app.Event {
+	h.t.Helper()
+	events := h.Events.Filter(model, eventType)
+	if len(events) == 0 {
+		h.t.Errorf("expected %s event for %s, recorded %d events", eventType, model, len(h.Events.Events()))
+		return nil
+	}
+	return events[len(events)-1]
+}
+
+func (h *Harness) AssertEventCount(model string, eventType string, count int) {
+	h.t.Helper()
+	if got := len(h.Events.Filter(model, eventType)); got != count {
+		h.t.Errorf("expected %d %s events for %s, got %d", count, eventType, model, got)
+	}
+}
+
+func (h *Harness) AssertNoEvents() {
+	h.t.Helper()
+	if events := h.Events.Events(); len(events) > 0 {
+		h.t.Errorf("expected no events, got %d (first %s %s)", len(events), events[0].Model, events[0].Type)
+	}
+}
+
+func (h *Harness) Client() *Client {
+	return &Client{h: h, header: http.Header{}}
+}
+
+func (h *Harness) Do(method string, path string, body interface{}) *Response {
+	h.t.Helper()
+	return h.Client().Do(method, path, body)
+}
+
+func (h *Harness) Get(path string) *Response {
+	h.t.Helper()
+	return h.Client().Get(path)
+}
+
+func (h *Harness) Post(path string, body interface{}) *Response {
+	h.t.Helper()
+	return h.Client().Post(path, body)
+}
+
+func (h *Harness) Put(path string, body interface{}) *Response {
+	h.t.Helper()
+	return h.Client().Put(path, body)
+}
+
+func (h *Harness) Delete(path string) *Response {
+	h.t.Helper()
+	return h.Client().Delete(path)
+}
+
+func (h *Harness) Request(req *http.Request) *Response {
+	h.t.Helper()
+	resp, err := h.Fiber().Test(req, -1)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		t:          h.t,
+	}
+}
+
+type Client struct {
+	h      *Harness
+	header http.Header
+}
+
+func (cl *Client) WithHeader(key string, value string) *Client {
+	header := cl.header.Clone()
+	header.Set(key, value)
+	return &Client{h: cl.h, header: header}
+}
+
+func (cl *Client) Header() http.Header {
+	return cl.header.Clone()
+}
+
+func (cl *Client) Do(method string, path string, body interface{}) *Response {
+	cl.h.t.Helper()
+	var reader io.Reader
+	switch val := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(val)
+	case string:
+		reader = strings.NewReader(val)
+	case io.Reader:
+		reader = val
+	default:
+		bb, err := json.Marshal(val)
+		if err != nil {
+			cl.h.t.Fatalf("%s %s: %v", method, path, err)
+		}
+		reader = bytes.NewReader(bb)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for key, values := range cl.header {
+		req.Header[key] = values
+	}
+	if body != nil && req.Header.Get(fiber.HeaderContentType) == "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	return cl.h.Request(req)
+}
+
+func (cl *Client) Get(path string) *Response {
+	cl.h.t.Helper()
+	return cl.Do(fiber.MethodGet, path, nil)
+}
+
+func (cl *Client) Post(path string, body interface{}) *Response {
+	cl.h.t.Helper()
+	return cl.Do(fiber.MethodPost, path, body)
+}
+
+func (cl *Client) Put(path string, body interface{}) *Response {
+	cl.h.t.Helper()
+	return cl.Do(fiber.MethodPut, path, body)
+}
+
+func (cl *Client) Delete(path string) *Response {
+	cl.h.t.Helper()
+	return cl.Do(fiber.MethodDelete, path, nil)
+}
+
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	t          testing.TB
+}
+
+func (r *Response) String() string {
+	return fmt.Sprintf("%d %s", r.StatusCode, r.Body)
+}
+
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.StatusCode != code {
+		r.t.Errorf("expected status %d, got %s", code, r)
+	}
+	return r
+}
+
+func (r *Response) JSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("decode response %s: %v", r, err)
+	}
+	return r
+}
+
+func (r *Response) Result(v interface{}) *Response {
+	r.t.Helper()
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	r.JSON(&envelope)
+	if err := json.Unmarshal(envelope.Result, v); err != nil {
+		r.t.Fatalf("decode result %s: %v", r, err)
+	}
+	return r
+}
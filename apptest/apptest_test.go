@@ -0,0 +1,82 @@
+package apptest_test
+
+import (
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"github.com/antandros/go-fiber-mapi/apptest"
+)
+
+type Task struct {
+	Title string
+	Done  bool
+}
+
+type taskResult struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+func TestHarnessCrudAndEvents(t *testing.T) {
+	h := apptest.New(t)
+	tasks := app.NewModel[Task]("task")
+	tasks.SoftDelete = true
+	h.Register(tasks)
+	client := h.As(app.M{})
+
+	h.Seed(tasks, app.M{"title": "seeded"})
+	h.AssertNoEvents()
+
+	var created taskResult
+	client.Post("/api/task/", app.M{"title": "write tests"}).AssertStatus(201).Result(&created)
+	if created.Id == "" || created.Title != "write tests" {
+		t.Fatalf("unexpected create result %+v", created)
+	}
+	event := h.AssertEvent("Task", app.EventCreated)
+	if event != nil && event.DocumentId != created.Id {
+		t.Fatalf("expected created event for %s, got %s", created.Id, event.DocumentId)
+	}
+
+	var fetched taskResult
+	client.Get("/api/task/" + created.Id).AssertStatus(200).Result(&fetched)
+	if fetched != created {
+		t.Fatalf("expected %+v, got %+v", created, fetched)
+	}
+	var list struct {
+		Items []taskResult `json:"items"`
+	}
+	client.Get("/api/task/").AssertStatus(200).Result(&list)
+	if len(list.Items) != 2 {
+		t.Fatalf("expected seeded and created tasks, got %+v", list.Items)
+	}
+
+	var updated taskResult
+	client.Put("/api/task/"+created.Id, app.M{"title": "write tests", "done": true}).AssertStatus(200).Result(&updated)
+	if !updated.Done {
+		t.Fatalf("expected update to persist, got %+v", updated)
+	}
+	h.AssertEventCount("Task", app.EventUpdated, 1)
+
+	client.Delete("/api/task/" + created.Id).AssertStatus(200)
+	client.Get("/api/task/" + created.Id).AssertStatus(404)
+	h.AssertEventCount("Task", app.EventDeleted, 1)
+	if docs := h.Documents(tasks); len(docs) != 2 {
+		t.Fatalf("expected soft delete to keep the document, got %d documents", len(docs))
+	}
+
+	client.Post("/api/task/"+created.Id+"/restore", nil).AssertStatus(200)
+	client.Get("/api/task/" + created.Id).AssertStatus(200)
+	h.AssertEventCount("Task", app.EventRestored, 1)
+	if got := len(h.Events.Events()); got != 4 {
+		t.Fatalf("expected 4 events, got %d", got)
+	}
+}
+
+func TestHarnessRequiresPrincipal(t *testing.T) {
+	h := apptest.New(t)
+	h.Register(app.NewModel[Task]("task"))
+	h.Get("/api/task/").AssertStatus(401)
+	h.Post("/api/task/", app.M{"title": "anonymous"}).AssertStatus(401)
+	h.AssertNoEvents()
+}
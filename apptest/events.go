@@ -0,0 +1,62 @@
+package apptest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/antandros/go-fiber-mapi/app"
+)
+
+type EventRecorder struct {
+	mu     sync.Mutex
+	events []*app.Event
+	Next   app.EventBus
+}
+
+func NewEventRecorder() *EventRecorder {
+	return &EventRecorder{}
+}
+
+func (er *EventRecorder) Publish(ctx context.Context, event *app.Event) error {
+	er.mu.Lock()
+	er.events = append(er.events, event)
+	er.mu.Unlock()
+	if er.Next != nil {
+		return er.Next.Publish(ctx, event)
+	}
+	return nil
+}
+
+func (er *EventRecorder) Transactional() bool {
+	return er.Next != nil && er.Next.Transactional()
+}
+
+func (er *EventRecorder) Events() []*app.Event {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return append([]*app.Event(nil), er.events...)
+}
+
+func (er *EventRecorder) Filter(model string, eventType string) []*app.Event {
+	var out []*app.Event
+	for _, event := range er.Events() {
+		if (model == "" || event.Model == model) && (eventType == "" || event.Type == eventType) {
+			out = append(out, event)
+		}
+	}
+	return out
+}
+
+func (er *EventRecorder) Reset() {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	er.events = nil
+}
+
+func (er *EventRecorder) truncate(size int) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if size < len(er.events) {
+		er.events = er.events[:size]
+	}
+}
@@ -0,0 +1,398 @@
+package apptest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func unsupported(kind string, name string) error {
+	return fmt.Errorf("%w: %s %s", app.ErrNotSupported, kind, name)
+}
+
+func operatorDoc(v interface{}) (bson.D, bool) {
+	doc, ok := v.(bson.D)
+	if !ok || len(doc) == 0 {
+		return nil, false
+	}
+	for _, item := range doc {
+		if !strings.HasPrefix(item.Key, "$") {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func matches(doc bson.D, query bson.D) (bool, error) {
+	for _, item := range query {
+		var ok bool
+		var err error
+		switch item.Key {
+		case "$and", "$or", "$nor":
+			ok, err = matchList(doc, item.Key, item.Value)
+		case "$expr":
+			var val interface{}
+			val, err = evalExpr(doc, item.Value)
+			ok = truthy(val)
+		default:
+			if strings.HasPrefix(item.Key, "$") {
+				return false, unsupported("query operator", item.Key)
+			}
+			val, exists := lookup(doc, item.Key)
+			ok, err = matchValue(val, exists, item.Value)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchList(doc bson.D, op string, value interface{}) (bool, error) {
+	list, ok := value.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("%s requires an array", op)
+	}
+	for _, item := range list {
+		query, ok := item.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("%s entries must be documents", op)
+		}
+		found, err := matches(doc, query)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !found:
+			return false, nil
+		case op == "$or" && found:
+			return true, nil
+		case op == "$nor" && found:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchValue(val interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, ok := operatorDoc(cond)
+	if !ok {
+		if re, ok := cond.(primitive.Regex); ok {
+			return matchRegex(val, re.Pattern, re.Options)
+		}
+		return equalMatch(val, cond), nil
+	}
+	for _, op := range ops {
+		ok, err := matchOperator(val, exists, op.Key, op.Value, ops)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func equalMatch(val interface{}, target interface{}) bool {
+	if compareValues(val, target) == 0 {
+		return true
+	}
+	if arr, ok := val.(bson.A); ok {
+		for _, item := range arr {
+			if compareValues(item, target) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rangeMatch(val interface{}, target interface{}, fnc func(c int) bool) bool {
+	if typeOrder(val) == typeOrder(target) && fnc(compareValues(val, target)) {
+		return true
+	}
+	if arr, ok := val.(bson.A); ok {
+		for _, item := range arr {
+			if typeOrder(item) == typeOrder(target) && fnc(compareValues(item, target)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRegex(val interface{}, pattern string, options string) (bool, error) {
+	flags := ""
+	for _, opt := range options {
+		if strings.ContainsRune("imsU", opt) {
+			flags += string(opt)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	if arr, ok := val.(bson.A); ok {
+		for _, item := range arr {
+			if str, ok := item.(string); ok && re.MatchString(str) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	str, ok := val.(string)
+	return ok && re.MatchString(str), nil
+}
+
+func inMatch(val interface{}, value interface{}) (bool, error) {
+	list, ok := value.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("$in needs an array")
+	}
+	for _, item := range list {
+		if re, ok := item.(primitive.Regex); ok {
+			found, err := matchRegex(val, re.Pattern, re.Options)
+			if err != nil || found {
+				return found, err
+			}
+		} else if equalMatch(val, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchOperator(val interface{}, exists bool, op string, value interface{}, ops bson.D) (bool, error) {
+	switch op {
+	case "$eq":
+		return equalMatch(val, value), nil
+	case "$ne":
+		return !equalMatch(val, value), nil
+	case "$gt":
+		return rangeMatch(val, value, func(c int) bool { return c > 0 }), nil
+	case "$gte":
+		return rangeMatch(val, value, func(c int) bool { return c >= 0 }), nil
+	case "$lt":
+		return rangeMatch(val, value, func(c int) bool { return c < 0 }), nil
+	case "$lte":
+		return rangeMatch(val, value, func(c int) bool { return c <= 0 }), nil
+	case "$in":
+		return inMatch(val, value)
+	case "$nin":
+		found, err := inMatch(val, value)
+		return !found, err
+	case "$exists":
+		return exists == truthy(value), nil
+	case "$regex":
+		options, _ := getField(ops, "$options")
+		optionStr, _ := options.(string)
+		switch pattern := value.(type) {
+		case string:
+			return matchRegex(val, pattern, optionStr)
+		case primitive.Regex:
+			return matchRegex(val, pattern.Pattern, pattern.Options+optionStr)
+		}
+		return false, fmt.Errorf("$regex has to be a string")
+	case "$options":
+		return true, nil
+	case "$not":
+		found, err := matchValue(val, exists, value)
+		return !found, err
+	case "$size":
+		arr, ok := val.(bson.A)
+		size, _ := toInt(value)
+		return ok && int64(len(arr)) == size, nil
+	case "$all":
+		list, ok := value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		for _, item := range list {
+			if !equalMatch(val, item) {
+				return false, nil
+			}
+		}
+		return len(list) > 0, nil
+	case "$elemMatch":
+		arr, ok := val.(bson.A)
+		if !ok {
+			return false, nil
+		}
+		for _, item := range arr {
+			var found bool
+			var err error
+			if _, isOps := operatorDoc(value); !isOps {
+				doc, isDoc := item.(bson.D)
+				query, _ := value.(bson.D)
+				if !isDoc {
+					continue
+				}
+				found, err = matches(doc, query)
+			} else {
+				found, err = matchValue(item, true, value)
+			}
+			if err != nil || found {
+				return found, err
+			}
+		}
+		return false, nil
+	}
+	return false, unsupported("query operator", op)
+}
+
+func sortDocs(docs []bson.D, spec bson.D) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, item := range spec {
+			a, _ := lookup(docs[i], item.Key)
+			b, _ := lookup(docs[j], item.Key)
+			c := compareValues(a, b)
+			if c == 0 {
+				continue
+			}
+			if dir, _ := toFloat(item.Value); dir < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+func upsertBase(query bson.D) bson.D {
+	doc := bson.D{}
+	for _, item := range query {
+		if strings.HasPrefix(item.Key, "$") {
+			continue
+		}
+		value := item.Value
+		if ops, ok := operatorDoc(value); ok {
+			eq, found := getField(ops, "$eq")
+			if !found {
+				continue
+			}
+			value = eq
+		}
+		doc = setValue(doc, item.Key, value).(bson.D)
+	}
+	return doc
+}
+
+func eachValues(value interface{}) bson.A {
+	if ops, ok := value.(bson.D); ok {
+		if each, found := getField(ops, "$each"); found {
+			return toArray(each)
+		}
+	}
+	return bson.A{value}
+}
+
+func applyUpdate(doc bson.D, update bson.D, insert bool) (bson.D, error) {
+	if _, ok := operatorDoc(update); !ok {
+		out := bson.D{}
+		if id, ok := getField(doc, "_id"); ok {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+		for _, item := range cloneDoc(update) {
+			if item.Key != "_id" || len(out) == 0 {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	}
+	var out interface{} = cloneDoc(doc)
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a document", op.Key)
+		}
+		for _, field := range fields {
+			current, exists := lookup(out, field.Key)
+			switch op.Key {
+			case "$set":
+				out = setValue(out, field.Key, field.Value)
+			case "$setOnInsert":
+				if insert {
+					out = setValue(out, field.Key, field.Value)
+				}
+			case "$unset":
+				out = unsetValue(out, field.Key)
+			case "$inc", "$mul":
+				if !exists {
+					current = int32(0)
+					if op.Key == "$mul" {
+						current = numberResult(0, isInteger(field.Value), false)
+					}
+				}
+				var value interface{}
+				var err error
+				if op.Key == "$inc" {
+					value, err = arithmetic(current, field.Value, func(x, y float64) float64 { return x + y })
+				} else {
+					value, err = arithmetic(current, field.Value, func(x, y float64) float64 { return x * y })
+				}
+				if err != nil {
+					return nil, err
+				}
+				out = setValue(out, field.Key, value)
+			case "$min", "$max":
+				c := compareValues(field.Value, current)
+				if !exists || (op.Key == "$min" && c < 0) || (op.Key == "$max" && c > 0) {
+					out = setValue(out, field.Key, field.Value)
+				}
+			case "$push", "$addToSet":
+				arr := toArray(current)
+				if exists && arr == nil && current != nil {
+					return nil, fmt.Errorf("%s used on non array field %s", op.Key, field.Key)
+				}
+				arr = append(bson.A{}, arr...)
+				for _, item := range eachValues(field.Value) {
+					if op.Key == "$addToSet" && equalMatch(arr, item) {
+						continue
+					}
+					arr = append(arr, item)
+				}
+				out = setValue(out, field.Key, arr)
+			case "$pull":
+				var arr bson.A
+				for _, item := range toArray(current) {
+					var found bool
+					var err error
+					doc, isDoc := item.(bson.D)
+					query, isQuery := field.Value.(bson.D)
+					if _, isOps := operatorDoc(field.Value); isOps || !isDoc || !isQuery {
+						found, err = matchValue(item, true, field.Value)
+					} else {
+						found, err = matches(doc, query)
+					}
+					if err != nil {
+						return nil, err
+					}
+					if !found {
+						arr = append(arr, item)
+					}
+				}
+				if exists {
+					out = setValue(out, field.Key, append(bson.A{}, arr...))
+				}
+			case "$rename":
+				target, ok := field.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("$rename target must be a string")
+				}
+				if exists {
+					out = unsetValue(out, field.Key)
+					out = setValue(out, target, current)
+				}
+			default:
+				return nil, unsupported("update operator", op.Key)
+			}
+		}
+	}
+	return out.(bson.D), nil
+}
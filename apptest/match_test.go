@@ -0,0 +1,189 @@
+package apptest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func extJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	out, err := bson.MarshalExtJSON(v, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestMatchOperators(t *testing.T) {
+	doc := bson.D{
+		{Key: "name", Value: "Widget"},
+		{Key: "qty", Value: int32(5)},
+		{Key: "price", Value: 2.5},
+		{Key: "tags", Value: bson.A{"red", "blue"}},
+		{Key: "sizes", Value: bson.A{
+			bson.D{{Key: "w", Value: int32(1)}, {Key: "h", Value: int32(2)}},
+			bson.D{{Key: "w", Value: int32(3)}, {Key: "h", Value: int32(4)}},
+		}},
+		{Key: "meta", Value: bson.D{{Key: "owner", Value: "ann"}}},
+	}
+	cases := []struct {
+		name  string
+		query bson.D
+		want  bool
+	}{
+		{"implicit eq", bson.D{{Key: "name", Value: "Widget"}}, true},
+		{"dotted path", bson.D{{Key: "meta.owner", Value: "ann"}}, true},
+		{"array contains", bson.D{{Key: "tags", Value: "red"}}, true},
+		{"$eq", bson.D{{Key: "qty", Value: bson.D{{Key: "$eq", Value: int64(5)}}}}, true},
+		{"$eq miss", bson.D{{Key: "qty", Value: bson.D{{Key: "$eq", Value: 6}}}}, false},
+		{"$ne", bson.D{{Key: "qty", Value: bson.D{{Key: "$ne", Value: 6}}}}, true},
+		{"$ne missing field", bson.D{{Key: "gone", Value: bson.D{{Key: "$ne", Value: 1}}}}, true},
+		{"$gt", bson.D{{Key: "qty", Value: bson.D{{Key: "$gt", Value: 4}}}}, true},
+		{"$gt equal", bson.D{{Key: "qty", Value: bson.D{{Key: "$gt", Value: 5}}}}, false},
+		{"$gte", bson.D{{Key: "qty", Value: bson.D{{Key: "$gte", Value: 5}}}}, true},
+		{"$lt", bson.D{{Key: "price", Value: bson.D{{Key: "$lt", Value: 3}}}}, true},
+		{"$lte", bson.D{{Key: "price", Value: bson.D{{Key: "$lte", Value: 2}}}}, false},
+		{"range", bson.D{{Key: "qty", Value: bson.D{{Key: "$gt", Value: 1}, {Key: "$lt", Value: 5}}}}, false},
+		{"$gt across types", bson.D{{Key: "name", Value: bson.D{{Key: "$gt", Value: 1}}}}, false},
+		{"$in", bson.D{{Key: "qty", Value: bson.D{{Key: "$in", Value: bson.A{1, 5}}}}}, true},
+		{"$in array field", bson.D{{Key: "tags", Value: bson.D{{Key: "$in", Value: bson.A{"blue"}}}}}, true},
+		{"$in regex", bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: bson.A{primitive.Regex{Pattern: "^wid", Options: "i"}}}}}}, true},
+		{"$nin", bson.D{{Key: "qty", Value: bson.D{{Key: "$nin", Value: bson.A{1, 2}}}}}, true},
+		{"$nin hit", bson.D{{Key: "tags", Value: bson.D{{Key: "$nin", Value: bson.A{"red"}}}}}, false},
+		{"$exists", bson.D{{Key: "meta", Value: bson.D{{Key: "$exists", Value: true}}}}, true},
+		{"$exists false", bson.D{{Key: "gone", Value: bson.D{{Key: "$exists", Value: false}}}}, true},
+		{"$regex", bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^Wid"}}}}, true},
+		{"$regex options", bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^wid"}, {Key: "$options", Value: "i"}}}}, true},
+		{"$regex case", bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^wid"}}}}, false},
+		{"regex value", bson.D{{Key: "name", Value: primitive.Regex{Pattern: "get$"}}}, true},
+		{"$not", bson.D{{Key: "qty", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 10}}}}}}, true},
+		{"$not hit", bson.D{{Key: "qty", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 1}}}}}}, false},
+		{"$size", bson.D{{Key: "tags", Value: bson.D{{Key: "$size", Value: 2}}}}, true},
+		{"$size miss", bson.D{{Key: "tags", Value: bson.D{{Key: "$size", Value: 1}}}}, false},
+		{"$all", bson.D{{Key: "tags", Value: bson.D{{Key: "$all", Value: bson.A{"blue", "red"}}}}}, true},
+		{"$all miss", bson.D{{Key: "tags", Value: bson.D{{Key: "$all", Value: bson.A{"red", "green"}}}}}, false},
+		{"$elemMatch document", bson.D{{Key: "sizes", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "w", Value: 3}, {Key: "h", Value: bson.D{{Key: "$gt", Value: 3}}}}}}}}, true},
+		{"$elemMatch document miss", bson.D{{Key: "sizes", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "w", Value: 1}, {Key: "h", Value: 4}}}}}}, false},
+		{"$elemMatch operators", bson.D{{Key: "tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$regex", Value: "^bl"}}}}}}, true},
+		{"$and", bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "qty", Value: 5}}, bson.D{{Key: "name", Value: "Widget"}}}}}, true},
+		{"$and miss", bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "qty", Value: 5}}, bson.D{{Key: "name", Value: "Gadget"}}}}}, false},
+		{"$or", bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "qty", Value: 1}}, bson.D{{Key: "name", Value: "Widget"}}}}}, true},
+		{"$or miss", bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "qty", Value: 1}}, bson.D{{Key: "name", Value: "Gadget"}}}}}, false},
+		{"$nor", bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "qty", Value: 1}}, bson.D{{Key: "name", Value: "Gadget"}}}}}, true},
+		{"$nor hit", bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "qty", Value: 5}}}}}, false},
+		{"$expr", bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{"$qty", "$price"}}}}}, true},
+		{"$expr miss", bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{bson.D{{Key: "$multiply", Value: bson.A{"$qty", "$price"}}}, 10}}}}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := toDoc(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := matches(doc, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %v for %s", tc.want, extJSON(t, tc.query))
+			}
+		})
+	}
+}
+
+func TestMatchUnsupportedOperator(t *testing.T) {
+	_, err := matches(bson.D{{Key: "qty", Value: 1}}, bson.D{{Key: "qty", Value: bson.D{{Key: "$near", Value: 1}}}})
+	if !errors.Is(err, app.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestUpdateOperators(t *testing.T) {
+	base := bson.D{
+		{Key: "_id", Value: int32(1)},
+		{Key: "name", Value: "Widget"},
+		{Key: "qty", Value: int32(5)},
+		{Key: "tags", Value: bson.A{"red", "blue"}},
+		{Key: "items", Value: bson.A{
+			bson.D{{Key: "sku", Value: "a"}, {Key: "n", Value: int32(1)}},
+			bson.D{{Key: "sku", Value: "b"}, {Key: "n", Value: int32(2)}},
+		}},
+	}
+	cases := []struct {
+		name   string
+		update bson.D
+		insert bool
+		want   string
+	}{
+		{"$set", bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Gadget"}, {Key: "meta.owner", Value: "ann"}}}}, false,
+			`{"_id":1,"name":"Gadget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"meta":{"owner":"ann"}}`},
+		{"$setOnInsert update", bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "created", Value: true}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$setOnInsert insert", bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "created", Value: true}}}}, true,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"created":true}`},
+		{"$unset", bson.D{{Key: "$unset", Value: bson.D{{Key: "tags", Value: ""}, {Key: "items", Value: ""}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5}`},
+		{"$inc", bson.D{{Key: "$inc", Value: bson.D{{Key: "qty", Value: int32(-2)}, {Key: "views", Value: int32(1)}}}}, false,
+			`{"_id":1,"name":"Widget","qty":3,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"views":1}`},
+		{"$mul", bson.D{{Key: "$mul", Value: bson.D{{Key: "qty", Value: int32(3)}, {Key: "missing", Value: int32(2)}}}}, false,
+			`{"_id":1,"name":"Widget","qty":15,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"missing":0}`},
+		{"$min", bson.D{{Key: "$min", Value: bson.D{{Key: "qty", Value: int32(2)}}}}, false,
+			`{"_id":1,"name":"Widget","qty":2,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$min keeps lower", bson.D{{Key: "$min", Value: bson.D{{Key: "qty", Value: int32(9)}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$max", bson.D{{Key: "$max", Value: bson.D{{Key: "qty", Value: int32(9)}, {Key: "peak", Value: int32(1)}}}}, false,
+			`{"_id":1,"name":"Widget","qty":9,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"peak":1}`},
+		{"$push", bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: "red"}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue","red"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$push $each", bson.D{{Key: "$push", Value: bson.D{{Key: "labels", Value: bson.D{{Key: "$each", Value: bson.A{"x", "y"}}}}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"labels":["x","y"]}`},
+		{"$addToSet", bson.D{{Key: "$addToSet", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: bson.A{"red", "green"}}}}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue","green"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$pull value", bson.D{{Key: "$pull", Value: bson.D{{Key: "tags", Value: "red"}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$pull condition", bson.D{{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "n", Value: bson.D{{Key: "$gt", Value: 1}}}}}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1}]}`},
+		{"$pull operators", bson.D{{Key: "$pull", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$in", Value: bson.A{"red", "blue"}}}}}}}, false,
+			`{"_id":1,"name":"Widget","qty":5,"tags":[],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}`},
+		{"$rename", bson.D{{Key: "$rename", Value: bson.D{{Key: "name", Value: "title"}, {Key: "missing", Value: "other"}}}}, false,
+			`{"_id":1,"qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}],"title":"Widget"}`},
+		{"replacement", bson.D{{Key: "name", Value: "Gadget"}}, false,
+			`{"_id":1,"name":"Gadget"}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			update, err := toDoc(tc.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := applyUpdate(base, update, tc.insert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out := extJSON(t, got); out != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, out)
+			}
+		})
+	}
+	if out := extJSON(t, base); out != `{"_id":1,"name":"Widget","qty":5,"tags":["red","blue"],"items":[{"sku":"a","n":1},{"sku":"b","n":2}]}` {
+		t.Fatalf("expected updates to leave the source document untouched, got %s", out)
+	}
+}
+
+func TestUpdateOperatorErrors(t *testing.T) {
+	base := bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "Widget"}}
+	cases := map[string]bson.D{
+		"push on scalar": {{Key: "$push", Value: bson.D{{Key: "name", Value: "x"}}}},
+		"inc on string":  {{Key: "$inc", Value: bson.D{{Key: "name", Value: 1}}}},
+		"unknown":        {{Key: "$bit", Value: bson.D{{Key: "name", Value: 1}}}},
+	}
+	for name, update := range cases {
+		if _, err := applyUpdate(base, update, false); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/antandros/go-fiber-mapi/app"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MemoryStore struct {
+	mu          sync.Mutex
+	txMu        sync.Mutex
+	collections map[string][]bson.D
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		collections: map[string][]bson.D{},
+	}
+}
+
+func (ms *MemoryStore) Collection(name string) app.StoreCollection {
+	return &memoryCollection{store: ms, name: name}
+}
+
+func (ms *MemoryStore) Transaction(ctx context.Context, fnc func(ctx context.Context) error) (err error) {
+	ms.txMu.Lock()
+	defer ms.txMu.Unlock()
+	snapshot := ms.snapshot()
+	defer func() {
+		if r := recover(); r != nil {
+			ms.restore(snapshot)
+			panic(r)
+		}
+		if err != nil {
+			ms.restore(snapshot)
+		}
+	}()
+	return fnc(ctx)
+}
+
+func (ms *MemoryStore) snapshot() map[string][]bson.D {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	out := make(map[string][]bson.D, len(ms.collections))
+	for name, docs := range ms.collections {
+		out[name] = append([]bson.D(nil), docs...)
+	}
+	return out
+}
+
+func (ms *MemoryStore) restore(snapshot map[string][]bson.D) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.collections = snapshot
+}
+
+func (ms *MemoryStore) Reset() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.collections = map[string][]bson.D{}
+}
+
+func (ms *MemoryStore) Documents(name string) []bson.M {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var out []bson.M
+	for _, doc := range ms.collections[name] {
+		var item bson.M
+		raw, _ := bson.Marshal(doc)
+		bson.Unmarshal(raw, &item)
+		out = append(out, item)
+	}
+	return out
+}
+
+type memoryCollection struct {
+	store *MemoryStore
+	name  string
+}
+
+func (mc *memoryCollection) docs() []bson.D {
+	return mc.store.collections[mc.name]
+}
+
+func (mc *memoryCollection) filter(filter app.M) ([]int, error) {
+	query, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []int
+	for i, doc := range mc.docs() {
+		ok, err := matches(doc, query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, i)
+		}
+	}
+	return found, nil
+}
+
+func (mc *memoryCollection) Find(ctx context.Context, filter app.M, opt app.FindOptions) (app.Cursor, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	found, err := mc.filter(filter)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]bson.D, 0, len(found))
+	for _, i := range found {
+		docs = append(docs, mc.docs()[i])
+	}
+	if opt.Sort != nil {
+		spec, err := toDoc(opt.Sort)
+		if err != nil {
+			return nil, err
+		}
+		sortDocs(docs, spec)
+	}
+	docs = window(docs, opt.Skip, opt.Limit)
+	return newCursor(docs)
+}
+
+func (mc *memoryCollection) FindOne(ctx context.Context, filter app.M) (bson.Raw, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	found, err := mc.filter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, app.ErrNoDocuments
+	}
+	return bson.Marshal(mc.docs()[found[0]])
+}
+
+func (mc *memoryCollection) Count(ctx context.Context, filter app.M) (int64, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	found, err := mc.filter(filter)
+	return int64(len(found)), err
+}
+
+func (mc *memoryCollection) insert(doc bson.D) (interface{}, error) {
+	id, ok := getField(doc, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+	for _, item := range mc.docs() {
+		if current, _ := getField(item, "_id"); compareValues(current, id) == 0 {
+			return nil, fmt.Errorf("%w: %s _id %v", app.ErrDuplicateKey, mc.name, id)
+		}
+	}
+	mc.store.collections[mc.name] = append(mc.docs(), doc)
+	return id, nil
+}
+
+func (mc *memoryCollection) Insert(ctx context.Context, doc app.M) (interface{}, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	item, err := toDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	return mc.insert(item)
+}
+
+func (mc *memoryCollection) Update(ctx context.Context, filter app.M, update app.M, opt app.UpdateOptions) (bson.Raw, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	found, err := mc.filter(filter)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		if !opt.Upsert {
+			return nil, app.ErrNoDocuments
+		}
+		query, err := toDoc(filter)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := applyUpdate(upsertBase(query), changes, true)
+		if err != nil {
+			return nil, err
+		}
+		_, err = mc.insert(doc)
+		if err != nil {
+			return nil, err
+		}
+		if !opt.ReturnAfter {
+			return nil, app.ErrNoDocuments
+		}
+		return bson.Marshal(mc.docs()[len(mc.docs())-1])
+	}
+	before := mc.docs()[found[0]]
+	after, err := applyUpdate(before, changes, false)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := getField(after, "_id"); !ok || compareValues(id, mustField(before, "_id")) != 0 {
+		return nil, errors.New("_id can not be modified")
+	}
+	mc.store.collections[mc.name][found[0]] = after
+	if opt.ReturnAfter {
+		return bson.Marshal(after)
+	}
+	return bson.Marshal(before)
+}
+
+func (mc *memoryCollection) Delete(ctx context.Context, filter app.M) (bson.Raw, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	found, err := mc.filter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, app.ErrNoDocuments
+	}
+	docs := mc.docs()
+	before := docs[found[0]]
+	rest := make([]bson.D, 0, len(docs)-1)
+	rest = append(rest, docs[:found[0]]...)
+	mc.store.collections[mc.name] = append(rest, docs[found[0]+1:]...)
+	return bson.Marshal(before)
+}
+
+func (mc *memoryCollection) Aggregate(ctx context.Context, pipeline []app.M) (app.Cursor, error) {
+	mc.store.mu.Lock()
+	defer mc.store.mu.Unlock()
+	stages := make([]interface{}, 0, len(pipeline))
+	for _, stage := range pipeline {
+		stages = append(stages, stage)
+	}
+	docs, err := mc.store.aggregate(append([]bson.D(nil), mc.docs()...), stages)
+	if err != nil {
+		return nil, err
+	}
+	return newCursor(docs)
+}
+
+func (mc *memoryCollection) Watch(ctx context.Context, pipeline []app.M, resume bson.Raw) (app.ChangeStream, error) {
+	return nil, app.ErrNotSupported
+}
+
+func window(docs []bson.D, skip int64, limit int64) []bson.D {
+	if skip > 0 {
+		if skip >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[skip:]
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+type memoryCursor struct {
+	docs    []bson.Raw
+	current bson.Raw
+}
+
+func newCursor(docs []bson.D) (*memoryCursor, error) {
+	cursor := &memoryCursor{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		cursor.docs = append(cursor.docs, raw)
+	}
+	return cursor, nil
+}
+
+func (cur *memoryCursor) Next(ctx context.Context) bool {
+	if len(cur.docs) == 0 {
+		cur.current = nil
+		return false
+	}
+	cur.current, cur.docs = cur.docs[0], cur.docs[1:]
+	return true
+}
+
+func (cur *memoryCursor) Decode(val interface{}) error {
+	if cur.current == nil {
+		return errors.New("cursor has no current document")
+	}
+	return bson.Unmarshal(cur.current, val)
+}
+
+func (cur *memoryCursor) All(ctx context.Context, results interface{}) error {
+	target := reflect.ValueOf(results)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+	slice := target.Elem()
+	if slice.Kind() == reflect.Interface {
+		slice = slice.Elem()
+	}
+	if slice.Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+	out := reflect.MakeSlice(slice.Type(), 0, len(cur.docs))
+	for cur.Next(ctx) {
+		elem := reflect.New(slice.Type().Elem())
+		err := cur.Decode(elem.Interface())
+		if err != nil {
+			return err
+		}
+		out = reflect.Append(out, elem.Elem())
+	}
+	target.Elem().Set(out)
+	return nil
+}
+
+func (cur *memoryCursor) Close(ctx context.Context) error {
+	cur.docs = nil
+	cur.current = nil
+	return nil
+}
+
+func (cur *memoryCursor) Err() error {
+	return nil
+}
@@ -0,0 +1,691 @@
+package apptest
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (ms *MemoryStore) aggregate(docs []bson.D, pipeline []interface{}) ([]bson.D, error) {
+	for _, stage := range pipeline {
+		spec, err := toDoc(stage)
+		if err != nil {
+			return nil, err
+		}
+		if len(spec) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must contain exactly one field")
+		}
+		name, arg := spec[0].Key, spec[0].Value
+		switch name {
+		case "$match":
+			query, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$match needs a document")
+			}
+			var out []bson.D
+			for _, doc := range docs {
+				found, err := matches(doc, query)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					out = append(out, doc)
+				}
+			}
+			docs = out
+		case "$sort":
+			order, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$sort needs a document")
+			}
+			docs = append([]bson.D(nil), docs...)
+			sortDocs(docs, order)
+		case "$skip", "$limit":
+			count, ok := toInt(arg)
+			if !ok || count < 0 {
+				return nil, fmt.Errorf("%s needs a positive integer", name)
+			}
+			if name == "$skip" {
+				docs = window(docs, count, 0)
+			} else {
+				docs = window(docs, 0, count)
+			}
+		case "$addFields", "$set":
+			fields, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("%s needs a document", name)
+			}
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) {
+				var out interface{} = cloneDoc(doc)
+				for _, field := range fields {
+					value, err := evalExpr(doc, field.Value)
+					if err != nil {
+						return nil, err
+					}
+					out = setValue(out, field.Key, value)
+				}
+				return out.(bson.D), nil
+			})
+		case "$unset":
+			fields := toArray(arg)
+			if field, ok := arg.(string); ok {
+				fields = bson.A{field}
+			}
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) {
+				var out interface{} = cloneDoc(doc)
+				for _, field := range fields {
+					out = unsetValue(out, fmt.Sprint(field))
+				}
+				return out.(bson.D), nil
+			})
+		case "$project":
+			fields, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$project needs a document")
+			}
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) {
+				return project(doc, fields)
+			})
+		case "$unwind":
+			docs, err = unwind(docs, arg)
+		case "$group":
+			fields, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$group needs a document")
+			}
+			docs, err = group(docs, fields)
+		case "$count":
+			field, ok := arg.(string)
+			if !ok || field == "" {
+				return nil, fmt.Errorf("$count needs a field name")
+			}
+			if len(docs) > 0 {
+				docs = []bson.D{{{Key: field, Value: int32(len(docs))}}}
+			}
+		case "$lookup":
+			fields, ok := arg.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("$lookup needs a document")
+			}
+			docs, err = ms.lookupStage(docs, fields)
+		case "$unionWith":
+			docs, err = ms.unionWith(docs, arg)
+		case "$replaceRoot", "$replaceWith":
+			expr := arg
+			if name == "$replaceRoot" {
+				fields, _ := arg.(bson.D)
+				expr, _ = getField(fields, "newRoot")
+			}
+			docs, err = mapDocs(docs, func(doc bson.D) (bson.D, error) {
+				value, err := evalExpr(doc, expr)
+				if err != nil {
+					return nil, err
+				}
+				root, ok := value.(bson.D)
+				if !ok {
+					return nil, fmt.Errorf("%s needs to evaluate to a document", name)
+				}
+				return root, nil
+			})
+		default:
+			return nil, unsupported("aggregation stage", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func mapDocs(docs []bson.D, fnc func(doc bson.D) (bson.D, error)) ([]bson.D, error) {
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		item, err := fnc(doc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+func projectFlag(v interface{}) (bool, bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0, true
+	}
+	return false, false
+}
+
+func project(doc bson.D, fields bson.D) (bson.D, error) {
+	inclusion := false
+	exclusion := false
+	keepId := true
+	includeId := false
+	for _, field := range fields {
+		include, isFlag := projectFlag(field.Value)
+		if field.Key == "_id" {
+			keepId = !isFlag || include
+			includeId = keepId
+			continue
+		}
+		if !isFlag || include {
+			inclusion = true
+		} else {
+			exclusion = true
+		}
+	}
+	if includeId && !exclusion {
+		inclusion = true
+	}
+	if !inclusion {
+		var out interface{} = cloneDoc(doc)
+		for _, field := range fields {
+			if _, isFlag := projectFlag(field.Value); isFlag && (field.Key != "_id" || !keepId) {
+				out = unsetValue(out, field.Key)
+			}
+		}
+		return out.(bson.D), nil
+	}
+	var out interface{} = bson.D{}
+	if id, ok := getField(doc, "_id"); ok && keepId {
+		out = setValue(out, "_id", id)
+	}
+	for _, field := range fields {
+		include, isFlag := projectFlag(field.Value)
+		if isFlag {
+			if include && field.Key != "_id" {
+				if value, ok := lookup(doc, field.Key); ok {
+					out = setValue(out, field.Key, value)
+				}
+			}
+			continue
+		}
+		value, err := evalExpr(doc, field.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = setValue(out, field.Key, value)
+	}
+	return out.(bson.D), nil
+}
+
+func unwind(docs []bson.D, arg interface{}) ([]bson.D, error) {
+	path, _ := arg.(string)
+	preserve := false
+	if fields, ok := arg.(bson.D); ok {
+		value, _ := getField(fields, "path")
+		path, _ = value.(string)
+		value, _ = getField(fields, "preserveNullAndEmptyArrays")
+		preserve = truthy(value)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("$unwind path must start with $")
+	}
+	path = path[1:]
+	var out []bson.D
+	for _, doc := range docs {
+		value, exists := lookup(doc, path)
+		arr, isArray := value.(bson.A)
+		switch {
+		case isArray && len(arr) > 0:
+			for _, item := range arr {
+				out = append(out, setValue(cloneDoc(doc), path, item).(bson.D))
+			}
+		case exists && !isArray && value != nil:
+			out = append(out, doc)
+		case preserve:
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+type groupState struct {
+	id     interface{}
+	values map[string]bson.A
+}
+
+func group(docs []bson.D, fields bson.D) ([]bson.D, error) {
+	idExpr, ok := getField(fields, "_id")
+	if !ok {
+		return nil, fmt.Errorf("$group needs an _id")
+	}
+	var order []string
+	groups := map[string]*groupState{}
+	for _, doc := range docs {
+		id, err := evalExpr(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+		if err != nil {
+			return nil, err
+		}
+		key := string(raw)
+		state, ok := groups[key]
+		if !ok {
+			state = &groupState{id: id, values: map[string]bson.A{}}
+			groups[key] = state
+			order = append(order, key)
+		}
+		for _, field := range fields {
+			if field.Key == "_id" {
+				continue
+			}
+			acc, ok := field.Value.(bson.D)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("$group field %s needs an accumulator", field.Key)
+			}
+			value, err := evalExpr(doc, acc[0].Value)
+			if err != nil {
+				return nil, err
+			}
+			state.values[field.Key] = append(state.values[field.Key], value)
+		}
+	}
+	out := make([]bson.D, 0, len(order))
+	for _, key := range order {
+		state := groups[key]
+		doc := bson.D{{Key: "_id", Value: state.id}}
+		for _, field := range fields {
+			if field.Key == "_id" {
+				continue
+			}
+			op := field.Value.(bson.D)[0].Key
+			value, err := accumulate(op, state.values[field.Key])
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.E{Key: field.Key, Value: value})
+		}
+		out = append(out, doc)
+	}
+	return out, nil
+}
+
+func accumulate(op string, values bson.A) (interface{}, error) {
+	switch op {
+	case "$sum", "$avg":
+		var total interface{} = int32(0)
+		count := 0
+		for _, value := range values {
+			if _, ok := toFloat(value); !ok {
+				continue
+			}
+			var err error
+			total, err = arithmetic(total, value, func(x, y float64) float64 { return x + y })
+			if err != nil {
+				return nil, err
+			}
+			count++
+		}
+		if op == "$sum" {
+			return total, nil
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		sum, _ := toFloat(total)
+		return sum / float64(count), nil
+	case "$count":
+		return int32(len(values)), nil
+	case "$min", "$max":
+		var result interface{}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			c := compareValues(value, result)
+			if result == nil || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+				result = value
+			}
+		}
+		return result, nil
+	case "$first", "$last":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		if op == "$first" {
+			return values[0], nil
+		}
+		return values[len(values)-1], nil
+	case "$push":
+		return append(bson.A{}, values...), nil
+	case "$addToSet":
+		out := bson.A{}
+		for _, value := range values {
+			if !equalMatch(out, value) {
+				out = append(out, value)
+			}
+		}
+		return out, nil
+	}
+	return nil, unsupported("accumulator", op)
+}
+
+func (ms *MemoryStore) lookupStage(docs []bson.D, fields bson.D) ([]bson.D, error) {
+	if _, ok := getField(fields, "let"); ok {
+		return nil, unsupported("$lookup option", "let")
+	}
+	value, _ := getField(fields, "from")
+	from, _ := value.(string)
+	value, _ = getField(fields, "localField")
+	localField, _ := value.(string)
+	value, _ = getField(fields, "foreignField")
+	foreignField, _ := value.(string)
+	value, _ = getField(fields, "as")
+	as, _ := value.(string)
+	value, hasPipeline := getField(fields, "pipeline")
+	pipeline := toArray(value)
+	if from == "" || as == "" || (localField == "") != (foreignField == "") || (localField == "" && !hasPipeline) {
+		return nil, fmt.Errorf("$lookup needs from, as and either localField and foreignField or a pipeline")
+	}
+	foreign := ms.collections[from]
+	return mapDocs(docs, func(doc bson.D) (bson.D, error) {
+		local, _ := lookup(doc, localField)
+		var candidates []bson.D
+		for _, item := range foreign {
+			found := localField == ""
+			if !found {
+				value, _ := lookup(item, foreignField)
+				found = equalMatch(value, local)
+				if arr, ok := local.(bson.A); ok && !found {
+					for _, elem := range arr {
+						if equalMatch(value, elem) {
+							found = true
+							break
+						}
+					}
+				}
+			}
+			if found {
+				candidates = append(candidates, cloneDoc(item))
+			}
+		}
+		if hasPipeline {
+			var err error
+			candidates, err = ms.aggregate(candidates, pipeline)
+			if err != nil {
+				return nil, err
+			}
+		}
+		joined := bson.A{}
+		for _, item := range candidates {
+			joined = append(joined, item)
+		}
+		return setValue(cloneDoc(doc), as, joined).(bson.D), nil
+	})
+}
+
+func (ms *MemoryStore) unionWith(docs []bson.D, arg interface{}) ([]bson.D, error) {
+	from, _ := arg.(string)
+	var pipeline bson.A
+	if fields, ok := arg.(bson.D); ok {
+		value, _ := getField(fields, "coll")
+		from, _ = value.(string)
+		value, _ = getField(fields, "pipeline")
+		pipeline = toArray(value)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("$unionWith needs a collection")
+	}
+	var other []bson.D
+	for _, item := range ms.collections[from] {
+		other = append(other, cloneDoc(item))
+	}
+	other, err := ms.aggregate(other, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]bson.D(nil), docs...), other...), nil
+}
+
+func evalExpr(doc bson.D, expr interface{}) (interface{}, error) {
+	switch val := expr.(type) {
+	case string:
+		if val == "$$ROOT" || val == "$$CURRENT" {
+			return doc, nil
+		}
+		if strings.HasPrefix(val, "$$ROOT.") || strings.HasPrefix(val, "$$CURRENT.") {
+			_, path, _ := strings.Cut(val, ".")
+			value, _ := lookup(doc, path)
+			return value, nil
+		}
+		if strings.HasPrefix(val, "$$") {
+			return nil, unsupported("variable", val)
+		}
+		if strings.HasPrefix(val, "$") {
+			value, _ := lookup(doc, val[1:])
+			return value, nil
+		}
+		return val, nil
+	case bson.A:
+		out := make(bson.A, 0, len(val))
+		for _, item := range val {
+			value, err := evalExpr(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, value)
+		}
+		return out, nil
+	case bson.D:
+		if len(val) == 1 && strings.HasPrefix(val[0].Key, "$") {
+			return evalOperator(doc, val[0].Key, val[0].Value)
+		}
+		out := bson.D{}
+		for _, item := range val {
+			value, err := evalExpr(doc, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, bson.E{Key: item.Key, Value: value})
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+func evalArgs(doc bson.D, arg interface{}) (bson.A, error) {
+	if list, ok := arg.(bson.A); ok {
+		value, err := evalExpr(doc, list)
+		if err != nil {
+			return nil, err
+		}
+		return value.(bson.A), nil
+	}
+	value, err := evalExpr(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	return bson.A{value}, nil
+}
+
+func evalOperator(doc bson.D, op string, arg interface{}) (interface{}, error) {
+	if op == "$literal" {
+		return arg, nil
+	}
+	if op == "$cond" {
+		var cond, then, other interface{}
+		if fields, ok := arg.(bson.D); ok {
+			cond, _ = getField(fields, "if")
+			then, _ = getField(fields, "then")
+			other, _ = getField(fields, "else")
+		} else if list := toArray(arg); len(list) == 3 {
+			cond, then, other = list[0], list[1], list[2]
+		} else {
+			return nil, fmt.Errorf("$cond needs if, then and else")
+		}
+		value, err := evalExpr(doc, cond)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(value) {
+			return evalExpr(doc, then)
+		}
+		return evalExpr(doc, other)
+	}
+	args, err := evalArgs(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	binary := func() (interface{}, interface{}, error) {
+		if len(args) != 2 {
+			return nil, nil, fmt.Errorf("%s needs two arguments", op)
+		}
+		return args[0], args[1], nil
+	}
+	switch op {
+	case "$add", "$multiply":
+		var result interface{} = int32(0)
+		if op == "$multiply" {
+			result = int32(1)
+		}
+		for _, value := range args {
+			if value == nil {
+				return nil, nil
+			}
+			if op == "$add" {
+				result, err = arithmetic(result, value, func(x, y float64) float64 { return x + y })
+			} else {
+				result, err = arithmetic(result, value, func(x, y float64) float64 { return x * y })
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case "$subtract", "$divide", "$mod":
+		a, b, err := binary()
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		switch op {
+		case "$subtract":
+			return arithmetic(a, b, func(x, y float64) float64 { return x - y })
+		case "$divide":
+			x, okx := toFloat(a)
+			y, oky := toFloat(b)
+			if !okx || !oky || y == 0 {
+				return nil, fmt.Errorf("$divide needs non zero numbers")
+			}
+			return x / y, nil
+		}
+		x, okx := toInt(a)
+		y, oky := toInt(b)
+		if !okx || !oky || y == 0 {
+			return nil, fmt.Errorf("$mod needs non zero integers")
+		}
+		return x % y, nil
+	case "$concat":
+		var sb strings.Builder
+		for _, value := range args {
+			if value == nil {
+				return nil, nil
+			}
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$concat only supports strings")
+			}
+			sb.WriteString(str)
+		}
+		return sb.String(), nil
+	case "$toLower", "$toUpper", "$toString":
+		if len(args) != 1 || args[0] == nil {
+			return nil, nil
+		}
+		str := fmt.Sprint(args[0])
+		if op == "$toLower" {
+			return strings.ToLower(str), nil
+		} else if op == "$toUpper" {
+			return strings.ToUpper(str), nil
+		}
+		return str, nil
+	case "$ifNull":
+		for _, value := range args {
+			if value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		a, b, err := binary()
+		if err != nil {
+			return nil, err
+		}
+		c := compareValues(a, b)
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		case "$lte":
+			return c <= 0, nil
+		}
+		return int32(c), nil
+	case "$and", "$or":
+		for _, value := range args {
+			if truthy(value) == (op == "$or") {
+				return op == "$or", nil
+			}
+		}
+		return op == "$and", nil
+	case "$not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("$not needs one argument")
+		}
+		return !truthy(args[0]), nil
+	case "$in":
+		a, b, err := binary()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range toArray(b) {
+			if compareValues(a, item) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$size":
+		if len(args) != 1 || toArray(args[0]) == nil && args[0] != nil {
+			return nil, fmt.Errorf("$size needs an array")
+		}
+		return int32(len(toArray(args[0]))), nil
+	case "$arrayElemAt":
+		a, b, err := binary()
+		if err != nil {
+			return nil, err
+		}
+		arr := toArray(a)
+		idx, ok := toInt(b)
+		if !ok {
+			return nil, fmt.Errorf("$arrayElemAt needs an integer index")
+		}
+		if idx < 0 {
+			idx += int64(len(arr))
+		}
+		if idx < 0 || idx >= int64(len(arr)) {
+			return nil, nil
+		}
+		return arr[idx], nil
+	case "$sum", "$avg", "$min", "$max":
+		if len(args) == 1 {
+			if arr := toArray(args[0]); arr != nil {
+				args = arr
+			}
+		}
+		return accumulate(op, args)
+	}
+	return nil, unsupported("expression operator", op)
+}
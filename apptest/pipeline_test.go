@@ -0,0 +1,174 @@
+package apptest
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func pipelineStore() *MemoryStore {
+	ms := NewMemoryStore()
+	ms.collections["orders"] = []bson.D{
+		{{Key: "_id", Value: int32(1)}, {Key: "customer", Value: "ann"}, {Key: "total", Value: int32(30)}, {Key: "items", Value: bson.A{"pen", "ink"}}},
+		{{Key: "_id", Value: int32(2)}, {Key: "customer", Value: "bob"}, {Key: "total", Value: int32(10)}, {Key: "items", Value: bson.A{"pad"}}},
+		{{Key: "_id", Value: int32(3)}, {Key: "customer", Value: "ann"}, {Key: "total", Value: int32(20)}, {Key: "items", Value: bson.A{}}},
+	}
+	ms.collections["customers"] = []bson.D{
+		{{Key: "_id", Value: "ann"}, {Key: "city", Value: "Oslo"}},
+		{{Key: "_id", Value: "bob"}, {Key: "city", Value: "Rome"}},
+	}
+	ms.collections["archive"] = []bson.D{
+		{{Key: "_id", Value: int32(9)}, {Key: "customer", Value: "cid"}, {Key: "total", Value: int32(5)}},
+	}
+	return ms
+}
+
+func runPipeline(t *testing.T, stages ...bson.D) string {
+	t.Helper()
+	ms := pipelineStore()
+	pipeline := make([]interface{}, 0, len(stages))
+	for _, stage := range stages {
+		pipeline = append(pipeline, stage)
+	}
+	docs, err := ms.aggregate(append([]bson.D(nil), ms.collections["orders"]...), pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		out = append(out, extJSON(t, doc))
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestPipelineStages(t *testing.T) {
+	cases := []struct {
+		name   string
+		stages []bson.D
+		want   []string
+	}{
+		{"$match", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "customer", Value: "ann"}}}},
+			{{Key: "$project", Value: bson.D{{Key: "total", Value: 1}}}},
+		}, []string{`{"_id":1,"total":30}`, `{"_id":3,"total":20}`}},
+		{"$sort", []bson.D{
+			{{Key: "$sort", Value: bson.D{{Key: "total", Value: 1}}}},
+			{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		}, []string{`{"_id":2}`, `{"_id":3}`, `{"_id":1}`}},
+		{"$skip and $limit", []bson.D{
+			{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}}}},
+			{{Key: "$skip", Value: 1}},
+			{{Key: "$limit", Value: 1}},
+			{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		}, []string{`{"_id":3}`}},
+		{"$addFields", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$addFields", Value: bson.D{{Key: "double", Value: bson.D{{Key: "$multiply", Value: bson.A{"$total", 2}}}}, {Key: "meta.count", Value: bson.D{{Key: "$size", Value: "$items"}}}}}},
+		}, []string{`{"_id":2,"customer":"bob","total":10,"items":["pad"],"double":20,"meta":{"count":1}}`}},
+		{"$set", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$set", Value: bson.D{{Key: "customer", Value: bson.D{{Key: "$toUpper", Value: "$customer"}}}}}},
+		}, []string{`{"_id":2,"customer":"BOB","total":10,"items":["pad"]}`}},
+		{"$unset", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$unset", Value: bson.A{"items", "total"}}},
+		}, []string{`{"_id":2,"customer":"bob"}`}},
+		{"$project exclusion", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$project", Value: bson.D{{Key: "items", Value: 0}, {Key: "_id", Value: 0}}}},
+		}, []string{`{"customer":"bob","total":10}`}},
+		{"$project expression", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "label", Value: bson.D{{Key: "$concat", Value: bson.A{"$customer", ":", bson.D{{Key: "$toString", Value: "$total"}}}}}}}}},
+		}, []string{`{"label":"bob:10"}`}},
+		{"$unwind", []bson.D{
+			{{Key: "$unwind", Value: "$items"}},
+			{{Key: "$project", Value: bson.D{{Key: "items", Value: 1}}}},
+		}, []string{`{"_id":1,"items":"pen"}`, `{"_id":1,"items":"ink"}`, `{"_id":2,"items":"pad"}`}},
+		{"$unwind preserve", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 3}}}},
+			{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$items"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}},
+			{{Key: "$project", Value: bson.D{{Key: "items", Value: 1}}}},
+		}, []string{`{"_id":3,"items":[]}`}},
+		{"$group", []bson.D{
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$customer"},
+				{Key: "sum", Value: bson.D{{Key: "$sum", Value: "$total"}}},
+				{Key: "avg", Value: bson.D{{Key: "$avg", Value: "$total"}}},
+				{Key: "min", Value: bson.D{{Key: "$min", Value: "$total"}}},
+				{Key: "max", Value: bson.D{{Key: "$max", Value: "$total"}}},
+				{Key: "first", Value: bson.D{{Key: "$first", Value: "$_id"}}},
+				{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+				{Key: "orders", Value: bson.D{{Key: "$count", Value: bson.D{}}}},
+			}}},
+		}, []string{
+			`{"_id":"ann","sum":50,"avg":25.0,"min":20,"max":30,"first":1,"ids":[1,3],"orders":2}`,
+			`{"_id":"bob","sum":10,"avg":10.0,"min":10,"max":10,"first":2,"ids":[2],"orders":1}`,
+		}},
+		{"$group null id", []bson.D{
+			{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "customers", Value: bson.D{{Key: "$addToSet", Value: "$customer"}}}}}},
+		}, []string{`{"_id":null,"customers":["ann","bob"]}`}},
+		{"$count", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "total", Value: bson.D{{Key: "$gte", Value: 20}}}}}},
+			{{Key: "$count", Value: "n"}},
+		}, []string{`{"n":2}`}},
+		{"$count empty", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "total", Value: 0}}}},
+			{{Key: "$count", Value: "n"}},
+		}, nil},
+		{"$lookup", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "localField", Value: "customer"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "who"}}}},
+			{{Key: "$project", Value: bson.D{{Key: "who", Value: 1}}}},
+		}, []string{`{"_id":2,"who":[{"_id":"bob","city":"Rome"}]}`}},
+		{"$lookup pipeline", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{{Key: "city", Value: "Oslo"}}}},
+				bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+			}}, {Key: "as", Value: "oslo"}}}},
+			{{Key: "$project", Value: bson.D{{Key: "oslo", Value: 1}}}},
+		}, []string{`{"_id":2,"oslo":[{"_id":"ann"}]}`}},
+		{"$unionWith", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$unionWith", Value: bson.D{{Key: "coll", Value: "archive"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$project", Value: bson.D{{Key: "total", Value: 1}}}}}}}}},
+			{{Key: "$project", Value: bson.D{{Key: "total", Value: 1}}}},
+		}, []string{`{"_id":2,"total":10}`, `{"_id":9,"total":5}`}},
+		{"$replaceRoot", []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 2}}}},
+			{{Key: "$addFields", Value: bson.D{{Key: "info.total", Value: "$total"}}}},
+			{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$info"}}}},
+		}, []string{`{"total":10}`}},
+		{"$replaceWith", []bson.D{
+			{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "localField", Value: "customer"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "who"}}}},
+			{{Key: "$match", Value: bson.D{{Key: "_id", Value: 1}}}},
+			{{Key: "$replaceWith", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{"$who", 0}}}}},
+		}, []string{`{"_id":"ann","city":"Oslo"}`}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := runPipeline(t, tc.stages...)
+			if want := strings.Join(tc.want, "\n"); got != want {
+				t.Fatalf("expected\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	ms := pipelineStore()
+	cases := map[string]bson.D{
+		"unknown stage":    {{Key: "$facet", Value: bson.D{}}},
+		"negative limit":   {{Key: "$limit", Value: -1}},
+		"unwind path":      {{Key: "$unwind", Value: "items"}},
+		"group without id": {{Key: "$group", Value: bson.D{{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		"lookup let":       {{Key: "$lookup", Value: bson.D{{Key: "from", Value: "customers"}, {Key: "let", Value: bson.D{}}, {Key: "pipeline", Value: bson.A{}}, {Key: "as", Value: "x"}}}},
+		"replace scalar":   {{Key: "$replaceWith", Value: "$total"}},
+	}
+	for name, stage := range cases {
+		if _, err := ms.aggregate(ms.collections["orders"], []interface{}{stage}); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
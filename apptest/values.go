@@ -0,0 +1,361 @@
+package apptest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	orderNull = iota
+	orderNumber
+	orderString
+	orderDocument
+	orderArray
+	orderBinary
+	orderObjectID
+	orderBool
+	orderDate
+	orderTimestamp
+	orderRegex
+	orderOther
+)
+
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out bson.D
+	err = bson.Unmarshal(raw, &out)
+	return out, err
+}
+
+func cloneDoc(doc bson.D) bson.D {
+	out, err := toDoc(doc)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func getField(doc bson.D, key string) (interface{}, bool) {
+	for _, item := range doc {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func mustField(doc bson.D, key string) interface{} {
+	val, _ := getField(doc, key)
+	return val
+}
+
+func lookup(target interface{}, path string) (interface{}, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	switch val := target.(type) {
+	case bson.D:
+		item, ok := getField(val, key)
+		if !ok || !nested {
+			return item, ok
+		}
+		return lookup(item, rest)
+	case bson.A:
+		if idx, err := strconv.Atoi(key); err == nil {
+			if idx < 0 || idx >= len(val) {
+				return nil, false
+			}
+			if !nested {
+				return val[idx], true
+			}
+			return lookup(val[idx], rest)
+		}
+		var out bson.A
+		for _, item := range val {
+			if found, ok := lookup(item, path); ok {
+				out = append(out, found)
+			}
+		}
+		return out, len(out) > 0
+	}
+	return nil, false
+}
+
+func setValue(target interface{}, path string, value interface{}) interface{} {
+	key, rest, nested := strings.Cut(path, ".")
+	if arr, ok := target.(bson.A); ok {
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 {
+			return arr
+		}
+		for len(arr) <= idx {
+			arr = append(arr, nil)
+		}
+		if nested {
+			arr[idx] = setValue(arr[idx], rest, value)
+		} else {
+			arr[idx] = value
+		}
+		return arr
+	}
+	doc, _ := target.(bson.D)
+	for i := range doc {
+		if doc[i].Key == key {
+			if nested {
+				doc[i].Value = setValue(doc[i].Value, rest, value)
+			} else {
+				doc[i].Value = value
+			}
+			return doc
+		}
+	}
+	if nested {
+		return append(doc, bson.E{Key: key, Value: setValue(bson.D{}, rest, value)})
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func unsetValue(target interface{}, path string) interface{} {
+	key, rest, nested := strings.Cut(path, ".")
+	switch val := target.(type) {
+	case bson.D:
+		for i := range val {
+			if val[i].Key != key {
+				continue
+			}
+			if nested {
+				val[i].Value = unsetValue(val[i].Value, rest)
+				return val
+			}
+			return append(val[:i], val[i+1:]...)
+		}
+	case bson.A:
+		if idx, err := strconv.Atoi(key); err == nil && idx >= 0 && idx < len(val) {
+			if nested {
+				val[idx] = unsetValue(val[idx], rest)
+			} else {
+				val[idx] = nil
+			}
+		}
+	}
+	return target
+}
+
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return orderNull
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, primitive.Decimal128:
+		return orderNumber
+	case string, primitive.Symbol:
+		return orderString
+	case bson.D, bson.M, map[string]interface{}:
+		return orderDocument
+	case bson.A, []interface{}:
+		return orderArray
+	case primitive.Binary, []byte:
+		return orderBinary
+	case primitive.ObjectID:
+		return orderObjectID
+	case bool:
+		return orderBool
+	case primitive.DateTime, time.Time:
+		return orderDate
+	case primitive.Timestamp:
+		return orderTimestamp
+	case primitive.Regex:
+		return orderRegex
+	}
+	return orderOther
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(val.String(), 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	}
+	f, ok := toFloat(v)
+	if !ok || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	}
+	return false
+}
+
+func numberResult(f float64, ints bool, wide bool) interface{} {
+	if ints {
+		if !wide && f >= math.MinInt32 && f <= math.MaxInt32 {
+			return int32(f)
+		}
+		return int64(f)
+	}
+	return f
+}
+
+func arithmetic(a interface{}, b interface{}, fnc func(x float64, y float64) float64) (interface{}, error) {
+	x, okx := toFloat(a)
+	y, oky := toFloat(b)
+	if !okx || !oky {
+		return nil, fmt.Errorf("can not apply arithmetic to %T and %T", a, b)
+	}
+	_, xwide := a.(int64)
+	_, ywide := b.(int64)
+	return numberResult(fnc(x, y), isInteger(a) && isInteger(b), xwide || ywide), nil
+}
+
+func toMillis(v interface{}) int64 {
+	switch val := v.(type) {
+	case primitive.DateTime:
+		return int64(val)
+	case time.Time:
+		return val.UnixMilli()
+	}
+	return 0
+}
+
+func compareInts(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareValues(a interface{}, b interface{}) int {
+	oa, ob := typeOrder(a), typeOrder(b)
+	if oa != ob {
+		return compareInts(int64(oa), int64(ob))
+	}
+	switch oa {
+	case orderNull:
+		return 0
+	case orderNumber:
+		x, _ := toFloat(a)
+		y, _ := toFloat(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case orderString:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	case orderDocument:
+		x, _ := toDoc(a)
+		y, _ := toDoc(b)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case orderArray:
+		x, y := toArray(a), toArray(b)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case orderBinary:
+		return bytes.Compare(toBytes(a), toBytes(b))
+	case orderObjectID:
+		x, y := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case orderBool:
+		x, y := a.(bool), b.(bool)
+		if x == y {
+			return 0
+		} else if !x {
+			return -1
+		}
+		return 1
+	case orderDate:
+		return compareInts(toMillis(a), toMillis(b))
+	case orderTimestamp:
+		x, y := a.(primitive.Timestamp), b.(primitive.Timestamp)
+		return primitive.CompareTimestamp(x, y)
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toArray(v interface{}) bson.A {
+	switch val := v.(type) {
+	case bson.A:
+		return val
+	case []interface{}:
+		return bson.A(val)
+	}
+	return nil
+}
+
+func toBytes(v interface{}) []byte {
+	switch val := v.(type) {
+	case primitive.Binary:
+		return val.Data
+	case []byte:
+		return val
+	}
+	return nil
+}
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return false
+	case bool:
+		return val
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
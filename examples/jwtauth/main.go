@@ -54,17 +54,12 @@ func loginFunc(dapp *app.App, c *fiber.Ctx) error {
 	if err != err {
 		panic(err)
 	}
-	resp := dapp.FindOneCollection("user", app.M{"mail": reqBody.Mail})
-	if resp.Err() != nil {
+	user, err := app.FindOne[User](app.TxContext(c), dapp, "user", app.M{"mail": reqBody.Mail})
+	if err != nil {
 		return c.JSON(LoginResponse{
 			Error: "mail or password didnt match",
 		})
 	}
-	var user User
-	err = resp.Decode(&user)
-	if err != nil {
-		panic(err)
-	}
 	hmacSampleSecret := []byte("AllYourBase")
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(reqBody.Password))
 	if err == nil {
@@ -156,5 +151,8 @@ func main() {
 		}
 
 	})
-	dapp.Run(":8766")
+	err := dapp.RunWithSignals(":8766")
+	if err != nil {
+		panic(err)
+	}
 }